@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AccessLogSlog is the response line written through the normal logger.
+	AccessLogSlog = "slog"
+	// AccessLogCommon is the Common Log Format of Apache and Nginx.
+	AccessLogCommon = "common"
+	// AccessLogCombined is the Common Log Format with the referer and user agent.
+	AccessLogCombined = "combined"
+	// AccessLogJson is a json object per line with all the fields of accessLogEntry.
+	AccessLogJson = "json"
+)
+
+var accessLogFormats = []string{AccessLogSlog, AccessLogCommon, AccessLogCombined, AccessLogJson}
+
+// accessLogEntry describes a request and the response to it.
+type accessLogEntry struct {
+	Time time.Time `json:"time"`
+	// RemoteAddr is the address of the client, which is taken from the forwarding headers of trusted proxies.
+	RemoteAddr string `json:"remote_addr"`
+	User       string `json:"user,omitempty"`
+	Method     string `json:"method"`
+	Uri        string `json:"uri"`
+	Proto      string `json:"proto"`
+	Status     int    `json:"status"`
+	Bytes      int64  `json:"bytes"`
+	// Duration is the time taken to serve the request in seconds.
+	Duration  float64 `json:"duration"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+	// Etag is the entity tag of the representation that was served or confirmed as not modified.
+	Etag        string `json:"etag,omitempty"`
+	NotModified bool   `json:"not_modified"`
+	TraceId     string `json:"trace_id,omitempty"`
+}
+
+// accessLogger writes an access log line for each request in the configured format, either through the normal logger
+// and stdout or to a separate file which can be reopened after it has been rotated.
+type accessLogger struct {
+	Format string
+	// Path is the optional file to append the access log to.
+	Path string
+	// Json writes the slog format as json rather than text, like -jsonlog does for the normal logger.
+	Json bool
+
+	lock   sync.Mutex
+	out    io.Writer
+	file   *os.File
+	logger *slog.Logger
+}
+
+func newAccessLogger(format, path string, json bool) (*accessLogger, error) {
+	if format == "" {
+		format = AccessLogSlog
+	}
+	l := &accessLogger{Format: format, Path: path, Json: json}
+	if path == "" {
+		if format != AccessLogSlog {
+			l.out = os.Stdout
+		}
+		return l, nil
+	}
+	return l, l.Reopen()
+}
+
+// Reopen opens the access log file again so that a log rotation that moved the previous file takes effect.
+func (l *accessLogger) Reopen() error {
+	if l.Path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the access log: %w", err)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	old := l.file
+	l.file, l.out, l.logger = f, f, nil
+	if old != nil {
+		_ = old.Close()
+	}
+	return nil
+}
+
+// Close closes the access log file, if any.
+func (l *accessLogger) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file, l.out, l.logger = nil, nil, nil
+	return err
+}
+
+// Log writes the entry, failures to write are reported through the normal logger.
+func (l *accessLogger) Log(entry accessLogEntry) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.Format == AccessLogSlog {
+		logger := slog.Default()
+		if l.out != nil {
+			if l.logger == nil {
+				if l.Json {
+					l.logger = slog.New(slog.NewJSONHandler(l.out, nil))
+				} else {
+					l.logger = slog.New(slog.NewTextHandler(l.out, nil))
+				}
+			}
+			logger = l.logger
+		}
+		args := []any{"method", entry.Method, "uri", entry.Uri, "status", entry.Status, "bytes", entry.Bytes}
+		if entry.User != "" {
+			args = append(args, "user", entry.User)
+		}
+		logger.Info("response", args...)
+		return
+	}
+	if l.out == nil {
+		return
+	}
+	if _, err := io.WriteString(l.out, formatAccessLogEntry(l.Format, entry)); err != nil {
+		slog.Error("failed to write the access log", "err", err)
+	}
+}
+
+// formatAccessLogEntry formats the entry as a line in one of the formats other than slog.
+func formatAccessLogEntry(format string, entry accessLogEntry) string {
+	if format == AccessLogJson {
+		raw, _ := json.Marshal(entry)
+		return string(raw) + "\n"
+	}
+	bytes := "-"
+	if entry.Bytes > 0 {
+		bytes = strconv.FormatInt(entry.Bytes, 10)
+	}
+	// the user is the only unquoted field which could contain a space
+	user := strings.ReplaceAll(escapeLogField(entry.User), " ", "%20")
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s",
+		orDash(entry.RemoteAddr), orDash(user), entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		escapeLogField(entry.Method), escapeLogField(entry.Uri), escapeLogField(entry.Proto), entry.Status, bytes)
+	if format == AccessLogCombined {
+		line += fmt.Sprintf(" \"%s\" \"%s\"", orDash(escapeLogField(entry.Referer)), orDash(escapeLogField(entry.UserAgent)))
+	}
+	return line + "\n"
+}
+
+func orDash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// escapeLogField escapes quotes and control characters so that a client cannot break the structure of the line.
+func escapeLogField(v string) string {
+	quoted := strconv.Quote(v)
+	return quoted[1 : len(quoted)-1]
+}
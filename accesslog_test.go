@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatAccessLogEntry(t *testing.T) {
+	entry := accessLogEntry{
+		Time:       time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)),
+		RemoteAddr: "127.0.0.1",
+		User:       "frank",
+		Method:     "GET",
+		Uri:        "/apache_pb.gif",
+		Proto:      "HTTP/1.0",
+		Status:     200,
+		Bytes:      2326,
+		Duration:   0.25,
+		Referer:    "http://www.example.com/start.html",
+		UserAgent:  "Mozilla/4.08 [en] (Win98; I ;Nav)",
+		Etag:       "abc",
+	}
+	assert.Equal(t, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`+"\n", formatAccessLogEntry(AccessLogCommon, entry))
+	assert.Equal(t, `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`+"\n", formatAccessLogEntry(AccessLogCombined, entry))
+	assert.Equal(t, `{"time":"2000-10-10T13:55:36-07:00","remote_addr":"127.0.0.1","user":"frank","method":"GET","uri":"/apache_pb.gif","proto":"HTTP/1.0","status":200,"bytes":2326,"duration":0.25,"referer":"http://www.example.com/start.html","user_agent":"Mozilla/4.08 [en] (Win98; I ;Nav)","etag":"abc","not_modified":false}`+"\n", formatAccessLogEntry(AccessLogJson, entry))
+
+	// empty fields are dashes and nothing the client sends can break out of the quotes
+	entry.User, entry.Bytes, entry.Referer, entry.UserAgent = "", 0, "", "evil\" agent\n"
+	assert.Equal(t, `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 - "-" "evil\" agent\n"`+"\n", formatAccessLogEntry(AccessLogCombined, entry))
+}
+
+func TestAccessLogger_reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	l, err := newAccessLogger(AccessLogCommon, path, false)
+	require.NoError(t, err)
+	defer l.Close()
+
+	entry := accessLogEntry{Time: time.Now(), RemoteAddr: "127.0.0.1", Method: "GET", Uri: "/", Proto: "HTTP/1.1", Status: 200}
+	l.Log(entry)
+	require.NoError(t, os.Rename(path, path+".1"))
+	l.Log(entry)
+	require.NoError(t, l.Reopen())
+	l.Log(entry)
+
+	rotated, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(rotated), "\n"))
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(current), "\n"))
+}
+
+func TestAccessLogger_slogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := newAccessLogger(AccessLogSlog, path, true)
+	require.NoError(t, err)
+	l.Log(accessLogEntry{Method: "GET", Uri: "/foo", Status: 404, Bytes: 19})
+	require.NoError(t, l.Close())
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(raw, &line))
+	assert.Equal(t, "response", line["msg"])
+	assert.Equal(t, "/foo", line["uri"])
+	assert.Equal(t, float64(404), line["status"])
+}
+
+func TestRunAccessLog(t *testing.T) {
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	logPath := filepath.Join(dir, "access.log")
+	baseUrl := startRun(t, argsStruct{
+		MarkdownFile:    mdPath,
+		AccessLogFormat: AccessLogJson,
+		AccessLogFile:   logPath,
+		TrustedProxies:  []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	}, nil)
+
+	resp, _ := getBody(t, baseUrl+"/")
+	request, err := http.NewRequest("GET", baseUrl+"/?x=1", nil)
+	require.NoError(t, err)
+	request.Header.Set("If-None-Match", resp.Header.Get("Etag"))
+	request.Header.Set("X-Forwarded-For", "198.51.100.1")
+	request.Header.Set("Referer", "http://example.com/")
+	request.Header.Set("User-Agent", "test-agent")
+	resp, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// rotate the log and ask for it to be reopened
+	require.NoError(t, os.Rename(logPath, logPath+".1"))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(logPath)
+		return err == nil
+	}, time.Second*5, time.Millisecond*20)
+	getBody(t, baseUrl+"/healthz")
+
+	rotated, err := os.ReadFile(logPath + ".1")
+	require.NoError(t, err)
+	lines := bytes.Split(bytes.TrimSpace(rotated), []byte("\n"))
+	var entry accessLogEntry
+	require.NoError(t, json.Unmarshal(lines[len(lines)-1], &entry))
+	assert.Equal(t, "198.51.100.1", entry.RemoteAddr)
+	assert.Equal(t, "/?x=1", entry.Uri)
+	assert.Equal(t, "HTTP/1.1", entry.Proto)
+	assert.Equal(t, http.StatusNotModified, entry.Status)
+	assert.True(t, entry.NotModified)
+	assert.Equal(t, resp.Header.Get("Etag"), entry.Etag)
+	assert.NotEmpty(t, entry.Etag)
+	assert.Equal(t, "http://example.com/", entry.Referer)
+	assert.Equal(t, "test-agent", entry.UserAgent)
+	assert.Greater(t, entry.Duration, float64(0))
+
+	current, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(current), `"uri":"/healthz"`)
+	assert.Contains(t, string(current), fmt.Sprintf(`"status":%d`, http.StatusOK))
+}
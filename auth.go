@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GehirnInc/crypt/sha256_crypt"
+	"github.com/GehirnInc/crypt/sha512_crypt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultAuthFailureLimit is the number of failed attempts a client may make in DefaultAuthFailureWindow before it
+	// is refused without its credentials being checked.
+	DefaultAuthFailureLimit  = 10
+	DefaultAuthFailureWindow = time.Minute
+	// maxTrackedClients bounds the memory used for the per client state.
+	maxTrackedClients = 10000
+	// maxVerifiedPasswords bounds the cache of verified passwords, which avoids a slow bcrypt comparison on every
+	// request.
+	maxVerifiedPasswords = 1000
+)
+
+// authenticator requires clients to authenticate with HTTP Basic auth against an htpasswd file, with a bearer token, by
+// logging in through OpenID Connect, or through an authenticating proxy, and optionally restricts the clients to
+// allow-lists.
+type authenticator struct {
+	// Htpasswd maps user names to their password hashes.
+	Htpasswd map[string]string
+	// Tokens maps the sha256 of each bearer token to its name.
+	Tokens map[[32]byte]string
+	// Exempt are the paths served without authentication, a path ending in '/' exempts everything below it.
+	Exempt         []string
+	TrustedProxies []netip.Prefix
+	Failures       *failureLimiter
+	// Oidc, when set, identifies clients by their session cookie and sends those without credentials to log in.
+	Oidc *oidcLogin
+	// IdentityProxies are the proxies whose identity headers are trusted.
+	IdentityProxies []netip.Prefix
+	// AllowUsers and AllowGroups restrict the authenticated clients, see identityAllowed.
+	AllowUsers  []string
+	AllowGroups []string
+
+	lock     sync.Mutex
+	verified map[[32]byte]bool
+}
+
+// loadHtpasswd reads an htpasswd file of 'user:hash' lines. The hashes can be bcrypt ('htpasswd -B'), SHA-1
+// ('htpasswd -s'), or SHA-256 and SHA-512 crypt ('htpasswd -2' and 'htpasswd -5').
+func loadHtpasswd(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the htpasswd file: %w", err)
+	}
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("failed to read the htpasswd file: line %d is not 'user:hash'", n)
+		}
+		if !supportedPasswordHash(hash) {
+			return nil, fmt.Errorf("failed to read the htpasswd file: the hash of '%s' is not bcrypt, {SHA}, $5$, or $6$", user)
+		}
+		users[user] = hash
+	}
+	return users, nil
+}
+
+// loadBearerTokens reads a file of bearer tokens, one per line. A line may name its token as 'name:token', otherwise
+// the token is named by its line number. Only a hash of each token is kept.
+func loadBearerTokens(path string) (map[[32]byte]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the bearer tokens file: %w", err)
+	}
+	tokens := make(map[[32]byte]string)
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, token, ok := strings.Cut(line, ":")
+		if !ok {
+			name, token = "token-"+strconv.Itoa(n), line
+		}
+		if token == "" {
+			return nil, fmt.Errorf("failed to read the bearer tokens file: line %d has an empty token", n)
+		}
+		tokens[sha256.Sum256([]byte(token))] = name
+	}
+	return tokens, nil
+}
+
+func supportedPasswordHash(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$", "{SHA}", "$5$", "$6$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyPassword returns whether the password matches the htpasswd hash.
+func verifyPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(hash[5:]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	case strings.HasPrefix(hash, "$5$"):
+		return sha256_crypt.New().Verify(hash, []byte(password)) == nil
+	case strings.HasPrefix(hash, "$6$"):
+		return sha512_crypt.New().Verify(hash, []byte(password)) == nil
+	default:
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+}
+
+// checkPassword is verifyPassword with a cache of the successful verifications.
+func (a *authenticator) checkPassword(user, password string) bool {
+	hash, ok := a.Htpasswd[user]
+	if !ok {
+		return false
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	a.lock.Lock()
+	cached := a.verified[key]
+	a.lock.Unlock()
+	if cached {
+		return true
+	}
+	if !verifyPassword(hash, password) {
+		return false
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.verified == nil || len(a.verified) >= maxVerifiedPasswords {
+		a.verified = make(map[[32]byte]bool)
+	}
+	a.verified[key] = true
+	return true
+}
+
+// probe returns whether the request is a liveness or readiness check. Orchestrators send them often, without
+// credentials, and from addresses that may not be allowed, so they are never authenticated, filtered by address, or rate
+// limited. The verbose readiness names local paths and errors, so it is treated like any other page.
+func probe(request *http.Request) bool {
+	return request.URL.Path == "/healthz" || (request.URL.Path == "/readyz" && !request.URL.Query().Has("verbose"))
+}
+
+func (a *authenticator) exempt(request *http.Request) bool {
+	path := request.URL.Path
+	return probe(request) || (a.Oidc != nil && (path == OidcCallbackPath || path == OidcLogoutPath)) || pathMatches(path, a.Exempt)
+}
+
+// pathMatches returns whether the path is one of the patterns, where a pattern ending in '/' matches everything below
+// it.
+func pathMatches(path string, patterns []string) bool {
+	for _, p := range patterns {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize returns the request with the identity of the client attached, or writes a 401, 403, or 429 response and
+// returns false. Exempt paths are let through without authentication.
+func (a *authenticator) Authorize(writer http.ResponseWriter, request *http.Request) (*http.Request, bool) {
+	if a.exempt(request) {
+		return request, true
+	}
+	request, ok := a.authenticate(writer, request)
+	if ok && !identityAllowed(requestIdentity(request), a.AllowUsers, a.AllowGroups) {
+		slog.Warn("authorization failed", "client", clientHost(request, a.TrustedProxies), "user", requestIdentity(request).Name, "uri", request.RequestURI)
+		http.Error(writer, "you are not allowed to view this page", http.StatusForbidden)
+		return request, false
+	}
+	return request, ok
+}
+
+// authenticate is Authorize without the exempt paths and allow-lists. Clients that were already authenticated, such as
+// by a client certificate, are let through.
+func (a *authenticator) authenticate(writer http.ResponseWriter, request *http.Request) (*http.Request, bool) {
+	if requestIdentity(request) != nil {
+		return request, true
+	}
+	if id := proxyIdentity(request, a.IdentityProxies); id != nil {
+		return withIdentity(request, id), true
+	}
+	if a.Oidc != nil {
+		if id := a.Oidc.Identify(request); id != nil {
+			return withIdentity(request, id), true
+		}
+	}
+	client := clientKey(request, a.TrustedProxies)
+	if retryAfter, blocked := a.Failures.Blocked(client, time.Now()); blocked {
+		writer.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+0.5)))
+		http.Error(writer, "too many failed authentication attempts", http.StatusTooManyRequests)
+		return request, false
+	}
+
+	var method, user string
+	if authorization := request.Header.Get("Authorization"); len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") && a.Tokens != nil {
+		method = "bearer"
+		if name, ok := a.Tokens[sha256.Sum256([]byte(strings.TrimSpace(authorization[7:])))]; ok {
+			return withIdentity(request, &identity{Name: name, Method: method}), true
+		}
+	} else if u, password, ok := request.BasicAuth(); ok && a.Htpasswd != nil {
+		method, user = "basic", u
+		if a.checkPassword(u, password) {
+			return withIdentity(request, &identity{Name: u, Method: method}), true
+		}
+	}
+
+	if method != "" {
+		a.Failures.Fail(client, time.Now())
+		slog.Warn("authentication failed", "client", clientHost(request, a.TrustedProxies), "method", method, "user", user, "uri", request.RequestURI)
+	} else if a.Oidc != nil {
+		a.Oidc.Login(writer, request)
+		return request, false
+	}
+	if a.Htpasswd != nil {
+		writer.Header().Add("WWW-Authenticate", `Basic realm="md-http", charset="UTF-8"`)
+	}
+	if a.Tokens != nil {
+		if method == "bearer" {
+			writer.Header().Add("WWW-Authenticate", `Bearer realm="md-http", error="invalid_token"`)
+		} else {
+			writer.Header().Add("WWW-Authenticate", `Bearer realm="md-http"`)
+		}
+	}
+	http.Error(writer, "authentication required", http.StatusUnauthorized)
+	return request, false
+}
+
+// failureLimiter counts the failed attempts of each client, see clientKey, in fixed windows.
+type failureLimiter struct {
+	Limit  int
+	Window time.Duration
+
+	lock    sync.Mutex
+	clients clientTable[*failureWindow]
+}
+
+type failureWindow struct {
+	Start time.Time
+	Count int
+}
+
+// Blocked returns whether the client has reached the limit in the current window, and when the window ends.
+func (l *failureLimiter) Blocked(client string, now time.Time) (time.Duration, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	w, ok := l.clients.Get(client)
+	if !ok || now.Sub(w.Start) >= l.Window || w.Count < l.Limit {
+		return 0, false
+	}
+	return w.Start.Add(l.Window).Sub(now), true
+}
+
+// Fail records a failed attempt by the client. When too many clients are tracked, the least recently seen one is
+// dropped.
+func (l *failureLimiter) Fail(client string, now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if w, ok := l.clients.Get(client); ok && now.Sub(w.Start) < l.Window {
+		w.Count++
+		return
+	}
+	l.clients.Put(client, &failureWindow{Start: now, Count: 1})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GehirnInc/crypt/sha256_crypt"
+	"github.com/GehirnInc/crypt/sha512_crypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	sha256Hash, err := sha256_crypt.New().Generate([]byte("secret"), []byte("$5$saltsalt"))
+	require.NoError(t, err)
+	sha512Hash, err := sha512_crypt.New().Generate([]byte("secret"), []byte("$6$saltsalt"))
+	require.NoError(t, err)
+
+	for _, hash := range []string{
+		string(bcryptHash),
+		// htpasswd -bns user secret
+		"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		sha256Hash,
+		sha512Hash,
+	} {
+		assert.True(t, supportedPasswordHash(hash), hash)
+		assert.True(t, verifyPassword(hash, "secret"), hash)
+		assert.False(t, verifyPassword(hash, "Secret"), hash)
+	}
+	assert.False(t, supportedPasswordHash("$apr1$salt$hash"))
+	assert.False(t, supportedPasswordHash("plain"))
+}
+
+func TestLoadHtpasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("# users\nalice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n\nbob:$2y$05$abc\n"), 0600))
+	users, err := loadHtpasswd(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"alice": "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "bob": "$2y$05$abc"}, users)
+
+	require.NoError(t, os.WriteFile(path, []byte("alice:$apr1$salt$hash\n"), 0600))
+	_, err = loadHtpasswd(path)
+	assert.EqualError(t, err, "failed to read the htpasswd file: the hash of 'alice' is not bcrypt, {SHA}, $5$, or $6$")
+	require.NoError(t, os.WriteFile(path, []byte("alice\n"), 0600))
+	_, err = loadHtpasswd(path)
+	assert.EqualError(t, err, "failed to read the htpasswd file: line 1 is not 'user:hash'")
+}
+
+func TestFailureLimiter(t *testing.T) {
+	l := &failureLimiter{Limit: 2, Window: time.Minute}
+	now := time.Now()
+	l.Fail("a", now)
+	_, blocked := l.Blocked("a", now)
+	assert.False(t, blocked)
+	l.Fail("a", now.Add(time.Second))
+	retryAfter, blocked := l.Blocked("a", now.Add(time.Second*10))
+	assert.True(t, blocked)
+	assert.Equal(t, time.Second*50, retryAfter)
+	_, blocked = l.Blocked("b", now)
+	assert.False(t, blocked)
+	_, blocked = l.Blocked("a", now.Add(time.Minute))
+	assert.False(t, blocked)
+
+	// the number of tracked clients is bounded
+	for i := 0; i < maxTrackedClients+10; i++ {
+		l.Fail(strings.Repeat("x", i), now.Add(time.Duration(i)))
+	}
+	assert.Equal(t, maxTrackedClients, l.clients.Len())
+}
+
+func TestRunAuth(t *testing.T) {
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	htpasswdPath := filepath.Join(dir, "htpasswd")
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(htpasswdPath, []byte("alice:"+string(hash)+"\n"), 0600))
+	tokensPath := filepath.Join(dir, "tokens")
+	require.NoError(t, os.WriteFile(tokensPath, []byte("ci:abc123\n"), 0600))
+	logPath := filepath.Join(dir, "access.log")
+	baseUrl := startRun(t, argsStruct{
+		MarkdownFile:     mdPath,
+		HtpasswdFile:     htpasswdPath,
+		BearerTokensFile: tokensPath,
+		AuthExempt:       []string{"/public/"},
+		AccessLogFormat:  AccessLogCommon,
+		AccessLogFile:    logPath,
+	}, nil)
+
+	get := func(path string, setup func(r *http.Request)) *http.Response {
+		request, err := http.NewRequest("GET", baseUrl+path, nil)
+		require.NoError(t, err)
+		setup(request)
+		resp, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+	anonymous := func(*http.Request) {}
+
+	resp := get("/", anonymous)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, []string{`Basic realm="md-http", charset="UTF-8"`, `Bearer realm="md-http"`}, resp.Header.Values("WWW-Authenticate"))
+	assert.Equal(t, http.StatusOK, get("/healthz", anonymous).StatusCode)
+	assert.Equal(t, http.StatusOK, get("/readyz", anonymous).StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, get("/readyz?verbose", anonymous).StatusCode)
+	// a single markdown file is served on every path
+	assert.Equal(t, http.StatusOK, get("/public/page", anonymous).StatusCode)
+	assert.Equal(t, http.StatusOK, get("/", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }).StatusCode)
+	assert.Equal(t, http.StatusOK, get("/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer abc123") }).StatusCode)
+
+	resp = get("/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") })
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Values("WWW-Authenticate"), `Bearer realm="md-http", error="invalid_token"`)
+
+	// after too many failures even the right password is refused
+	for i := 1; i < DefaultAuthFailureLimit; i++ {
+		assert.Equal(t, http.StatusUnauthorized, get("/", func(r *http.Request) { r.SetBasicAuth("alice", "wrong") }).StatusCode)
+	}
+	resp = get("/", func(r *http.Request) { r.SetBasicAuth("alice", "secret") })
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, get("/healthz", anonymous).StatusCode)
+
+	raw, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Regexp(t, `(?m)^127\.0\.0\.1 - alice \[.+\] "GET / HTTP/1\.1" 200 \d+$`, string(raw))
+	assert.Regexp(t, `(?m)^127\.0\.0\.1 - ci \[.+\] "GET / HTTP/1\.1" 200 \d+$`, string(raw))
+	assert.Regexp(t, `(?m)^127\.0\.0\.1 - - \[.+\] "GET / HTTP/1\.1" 401 \d+$`, string(raw))
+}
+
+func TestRunIdentityProxy(t *testing.T) {
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	templatePath := filepath.Join(dir, "page.html")
+	require.NoError(t, os.WriteFile(templatePath, []byte(`{{ with .User }}<p>{{ .Name }} ({{ .Email }})</p>{{ end }}{{ .Body }}`), 0600))
+	logPath := filepath.Join(dir, "access.log")
+	baseUrl := startRun(t, argsStruct{
+		MarkdownFile:    mdPath,
+		TemplateFile:    templatePath,
+		IdentityProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+		AllowGroups:     []string{"docs"},
+		AccessLogFormat: AccessLogCommon,
+		AccessLogFile:   logPath,
+	}, nil)
+
+	get := func(headers map[string]string) (*http.Response, string) {
+		request, err := http.NewRequest("GET", baseUrl+"/", nil)
+		require.NoError(t, err)
+		for k, v := range headers {
+			request.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, _ := get(nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = get(map[string]string{"X-Forwarded-User": "bob", "X-Forwarded-Groups": "eng"})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, body := get(map[string]string{"X-Forwarded-User": "alice", "X-Forwarded-Email": "alice@example.com", "X-Forwarded-Groups": "eng,docs"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "<p>alice (alice@example.com)</p>")
+	assert.Equal(t, "private", resp.Header.Get("Cache-Control"))
+
+	raw, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Regexp(t, `(?m)^127\.0\.0\.1 - alice \[.+\] "GET / HTTP/1\.1" 200 \d+$`, string(raw))
+	assert.Regexp(t, `(?m)^127\.0\.0\.1 - bob \[.+\] "GET / HTTP/1\.1" 403 \d+$`, string(raw))
+}
@@ -0,0 +1,50 @@
+package main
+
+import "container/list"
+
+// clientTable holds state per client. It is bounded to maxTrackedClients by dropping the least recently used client, so
+// that a flood of new clients costs the same per request as any other. It is not safe for concurrent use.
+type clientTable[T any] struct {
+	entries map[string]*list.Element
+	// recent orders the clients from the most to the least recently used.
+	recent list.List
+}
+
+type clientEntry[T any] struct {
+	Client string
+	State  T
+}
+
+// Get returns the state of the client and marks it as recently used.
+func (t *clientTable[T]) Get(client string) (T, bool) {
+	if e, ok := t.entries[client]; ok {
+		t.recent.MoveToFront(e)
+		return e.Value.(*clientEntry[T]).State, true
+	}
+	var zero T
+	return zero, false
+}
+
+// Put sets the state of the client and marks it as recently used, dropping the least recently used client if the table
+// is full.
+func (t *clientTable[T]) Put(client string, state T) {
+	if e, ok := t.entries[client]; ok {
+		e.Value.(*clientEntry[T]).State = state
+		t.recent.MoveToFront(e)
+		return
+	}
+	if t.entries == nil {
+		t.entries = make(map[string]*list.Element)
+	}
+	if len(t.entries) >= maxTrackedClients {
+		oldest := t.recent.Back()
+		delete(t.entries, oldest.Value.(*clientEntry[T]).Client)
+		t.recent.Remove(oldest)
+	}
+	t.entries[client] = t.recent.PushFront(&clientEntry[T]{Client: client, State: state})
+}
+
+// Len returns the number of clients in the table.
+func (t *clientTable[T]) Len() int {
+	return len(t.entries)
+}
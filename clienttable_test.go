@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientTable(t *testing.T) {
+	var table clientTable[int]
+	_, ok := table.Get("0")
+	assert.False(t, ok)
+	assert.Equal(t, 0, table.Len())
+	for i := 0; i < maxTrackedClients; i++ {
+		table.Put(strconv.Itoa(i), i)
+	}
+	// using a client keeps it, and the least recently used one is dropped instead
+	v, ok := table.Get("0")
+	assert.True(t, ok)
+	assert.Equal(t, 0, v)
+	table.Put("new", -1)
+	assert.Equal(t, maxTrackedClients, table.Len())
+	_, ok = table.Get("0")
+	assert.True(t, ok)
+	_, ok = table.Get("1")
+	assert.False(t, ok)
+	table.Put("new", -2)
+	v, _ = table.Get("new")
+	assert.Equal(t, -2, v)
+	assert.Equal(t, maxTrackedClients, table.Len())
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encodingOrder is the content codings that representations are compressed with, in order of preference when the
+// client accepts several of them equally.
+var encodingOrder = []string{"br", "zstd", "gzip"}
+
+// minCompressSize is the body size below which compression isn't worth the bytes it saves.
+const minCompressSize = 256
+
+// zstdEncoder is shared since EncodeAll is safe for concurrent use.
+var zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+	return zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+})
+
+// compressBody compresses the body with the content coding.
+func compressBody(encoding string, body []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	switch encoding {
+	case "br":
+		w := brotli.NewWriterLevel(&buffer, brotli.DefaultCompression)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		} else if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case "zstd":
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(body, nil), nil
+	case "gzip":
+		w, err := gzip.NewWriterLevel(&buffer, gzip.DefaultCompression)
+		if err != nil {
+			return nil, err
+		} else if _, err := w.Write(body); err != nil {
+			return nil, err
+		} else if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content coding '%s'", encoding)
+	}
+	return buffer.Bytes(), nil
+}
+
+// compressedBodies holds the body of a representation in each content coding that makes it smaller. They are computed
+// once, on first use, so that loading the content, which holds up edits and reloads, doesn't pay for them.
+type compressedBodies struct {
+	once   sync.Once
+	bodies map[string][]byte
+}
+
+// compressible returns the representation with its body available compressed, see representation.Encoded. Small
+// bodies are left alone.
+func compressible(rep representation) representation {
+	if len(rep.Body) >= minCompressSize {
+		rep.compressed = &compressedBodies{}
+	}
+	return rep
+}
+
+// Compressible returns whether the body may be served compressed.
+func (r representation) Compressible() bool {
+	return r.compressed != nil
+}
+
+// Encoded returns the body in each content coding that makes it smaller, compressing it on the first call.
+func (r representation) Encoded() map[string][]byte {
+	if r.compressed == nil {
+		return nil
+	}
+	r.compressed.once.Do(func() {
+		r.compressed.bodies = make(map[string][]byte, len(encodingOrder))
+		for _, encoding := range encodingOrder {
+			encoded, err := compressBody(encoding, r.Body)
+			if err != nil {
+				slog.Error("failed to compress", "encoding", encoding, "err", err)
+			} else if len(encoded) < len(r.Body) {
+				r.compressed.bodies[encoding] = encoded
+			}
+		}
+	})
+	return r.compressed.bodies
+}
+
+// negotiateEncoding returns the available content coding that the Accept-Encoding header rates highest, preferring the
+// order of encodingOrder when they are rated the same. An empty string means the body is sent as is. The identity coding
+// is always acceptable to us, so a request that refuses it still gets an uncompressed body rather than a 406.
+func negotiateEncoding(acceptEncoding string, available map[string][]byte) string {
+	if len(available) == 0 || strings.TrimSpace(acceptEncoding) == "" {
+		return ""
+	}
+	ratings := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if coding != "" {
+			ratings[coding] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range encodingOrder {
+		if _, ok := available[encoding]; !ok {
+			continue
+		}
+		q, ok := ratings[encoding]
+		if !ok {
+			q = ratings["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// encodedEtag is the etag of the representation when served with the content coding. Each coding has its own etag since
+// the bytes differ, so that caches never mix them up.
+func encodedEtag(hash, encoding string) string {
+	if encoding == "" {
+		return hash
+	}
+	return hash + "-" + encoding
+}
+
+// etagHash returns the representation hash that an etag from encodedEtag was built from. Either form identifies the
+// same content, so preconditions on edits accept both.
+func etagHash(etag string) string {
+	for _, encoding := range encodingOrder {
+		if hash, ok := strings.CutSuffix(etag, "-"+encoding); ok {
+			return hash
+		}
+	}
+	return etag
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	available := map[string][]byte{"br": nil, "zstd": nil, "gzip": nil}
+	for acceptEncoding, expected := range map[string]string{
+		"":                         "",
+		"identity":                 "",
+		"gzip":                     "gzip",
+		"GZIP":                     "gzip",
+		"gzip, deflate, br":        "br",
+		"gzip, deflate, br, zstd":  "br",
+		"br;q=0.5, zstd":           "zstd",
+		"*":                        "br",
+		"*;q=0.5, gzip":            "gzip",
+		"*, br;q=0":                "zstd",
+		"gzip;q=0":                 "",
+		"gzip;q=bogus, zstd;q=0.1": "zstd",
+		"deflate, compress":        "",
+	} {
+		assert.Equal(t, expected, negotiateEncoding(acceptEncoding, available), acceptEncoding)
+	}
+	assert.Equal(t, "gzip", negotiateEncoding("br, gzip", map[string][]byte{"gzip": nil}))
+	assert.Equal(t, "", negotiateEncoding("br, gzip", nil))
+}
+
+func TestCompressible(t *testing.T) {
+	rep := compressible(newRepresentation("text/plain", []byte("tiny")))
+	assert.False(t, rep.Compressible())
+	assert.Empty(t, rep.Encoded())
+
+	body := []byte(strings.Repeat("the same line over and over\n", 100))
+	rep = compressible(newRepresentation("text/plain", body))
+	assert.True(t, rep.Compressible())
+	assert.Nil(t, rep.compressed.bodies)
+	encoded := rep.Encoded()
+	require.Len(t, encoded, 3)
+	// copies of the representation share the compressed bodies
+	copied := rep
+	assert.Equal(t, encoded, copied.Encoded())
+
+	r, err := gzip.NewReader(bytes.NewReader(encoded["gzip"]))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, body, decoded)
+	decoded, err = io.ReadAll(brotli.NewReader(bytes.NewReader(encoded["br"])))
+	require.NoError(t, err)
+	assert.Equal(t, body, decoded)
+	d, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer d.Close()
+	decoded, err = d.DecodeAll(encoded["zstd"], nil)
+	require.NoError(t, err)
+	assert.Equal(t, body, decoded)
+}
+
+func TestContentStore_compressesLazily(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 10; i++ {
+		page := fmt.Sprintf("# Page %d\n\n%s", i, strings.Repeat("Some text with a [link](other.md) and `code`.\n\n", 20))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("page%d.md", i)), []byte(page), 0600))
+	}
+
+	// loads hold up edits and reloads, so they must not pay for compressing every variant of every page
+	store := &contentStore{MarkdownPath: dir}
+	require.NoError(t, store.Load())
+	pages := store.Snapshot().Pages
+	for route, p := range pages {
+		for mediaType, rep := range p.Variants {
+			require.True(t, rep.Compressible(), route, mediaType)
+			assert.Nil(t, rep.compressed.bodies, route, mediaType)
+		}
+	}
+
+	// the first request that accepts an encoding compresses that representation, and later ones reuse the bodies
+	rep := pages["/page0"].Variants["text/html"]
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/page0", nil)
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		recorder := httptest.NewRecorder()
+		writeRepresentation(recorder, request, rep)
+		return recorder
+	}
+	assert.Equal(t, "br", get("br").Header().Get("Content-Encoding"))
+	require.NotNil(t, rep.compressed.bodies)
+	compressed := rep.compressed.bodies
+	assert.Equal(t, "gzip", get("gzip").Header().Get("Content-Encoding"))
+	assert.Equal(t, compressed, rep.compressed.bodies)
+	assert.Nil(t, pages["/page1"].Variants["text/html"].compressed.bodies)
+}
+
+func TestEtagHash(t *testing.T) {
+	assert.Equal(t, "abc", etagHash("abc"))
+	assert.Equal(t, "abc", etagHash(encodedEtag("abc", "br")))
+	assert.Equal(t, "abc", etagHash(encodedEtag("abc", "zstd")))
+	assert.Equal(t, "abc", etagHash(encodedEtag("abc", "gzip")))
+	assert.Equal(t, "abc", encodedEtag("abc", ""))
+}
+
+func TestRunCompression(t *testing.T) {
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n\n"+strings.Repeat("Some repetitive text.\n", 50)), 0600))
+	cssPath := filepath.Join(dir, "example.css")
+	require.NoError(t, os.WriteFile(cssPath, []byte(strings.Repeat("p { margin: 0; }\n", 50)), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, CssUrl: cssPath}, nil)
+
+	// the transport would otherwise ask for gzip and transparently decode it
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(path, acceptEncoding, ifNoneMatch string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(http.MethodGet, baseUrl+path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, data
+	}
+
+	resp, plain := get("/", "", "")
+	assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"Accept, User-Agent", "Accept-Encoding"}, resp.Header.Values("Vary"))
+	plainEtag := resp.Header.Get("Etag")
+
+	resp, data := get("/", "gzip, deflate", "")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, plainEtag+"-gzip", resp.Header.Get("Etag"))
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, plain, decoded)
+
+	resp, data = get("/", "gzip, br", "")
+	assert.Equal(t, "br", resp.Header.Get("Content-Encoding"))
+	brEtag := resp.Header.Get("Etag")
+	assert.Equal(t, plainEtag+"-br", brEtag)
+	decoded, err = io.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+	require.NoError(t, err)
+	assert.Equal(t, plain, decoded)
+
+	// each encoding only matches its own etag
+	resp, _ = get("/", "gzip, br", brEtag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	resp, _ = get("/", "gzip", brEtag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = get("/", "", brEtag)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, data = get("/default.css", "zstd", "")
+	assert.Equal(t, "text/css; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "zstd", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	d, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer d.Close()
+	decoded, err = d.DecodeAll(data, nil)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("p { margin: 0; }\n", 50), string(decoded))
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/russross/blackfriday"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// page is a rendered version of a markdown document.
+type page struct {
+	// Route is the url path the page is served on.
+	Route string
+	// Source is the slash separated path of the markdown file relative to the served directory.
+	Source string
+	// Path is the path of the markdown file on disk.
+	Path string
+	// Raw is the markdown file content as read from disk.
+	Raw  []byte
+	Html []byte
+	Hash string
+	// Variants holds every representation of the page by media type, including the html above.
+	Variants map[string]representation
+	// Personalize renders the html for the given user. It is only set when the template uses the user.
+	Personalize func(user *identity) (representation, error)
+}
+
+// snapshot is an immutable set of everything we serve. A snapshot is never modified after it is created, when the
+// sources change a new snapshot is built and swapped in so in-flight requests keep a consistent body and etag.
+type snapshot struct {
+	// Pages is the set of rendered pages by route.
+	Pages map[string]*page
+	// Fallback is served for unknown routes. This is only set when serving a single file.
+	Fallback *page
+	// Sources is the list of files and directories that the snapshot was built from.
+	Sources []string
+	Css     []byte
+	// CssVariant is the css as it is served.
+	CssVariant representation
+	Favicon    []byte
+	// Replaced is closed when this snapshot is replaced by a newer one.
+	Replaced chan struct{}
+	// Hash identifies the content of all the pages and assets together.
+	Hash string
+	// LoadedAt is the time that the snapshot was built.
+	LoadedAt time.Time
+}
+
+// PageForSource returns the page built from the markdown file at the given url path, such as '/foo/bar.md'. When
+// serving a single file its source is available as '/index.md'.
+func (s *snapshot) PageForSource(urlPath string) (*page, bool) {
+	rel := strings.TrimPrefix(urlPath, "/")
+	if s.Fallback != nil {
+		return s.Fallback, rel == "index.md"
+	}
+	p, ok := s.Pages[routeForSource(rel)]
+	return p, ok && p.Source == rel
+}
+
+// contentStore holds the snapshot currently being served and knows how to rebuild it from the source files.
+type contentStore struct {
+	current atomic.Pointer[snapshot]
+	// lastLoad is the result of the most recent attempt to load the content.
+	lastLoad atomic.Pointer[loadResult]
+	// loads and loadFailures count the attempts to load the content.
+	loads, loadFailures atomic.Uint64
+	// loadLock ensures that loads and updates happen one at a time, so that an older snapshot never replaces a newer one.
+	loadLock sync.Mutex
+
+	// MarkdownPath is the markdown file or directory of markdown files to serve.
+	MarkdownPath string
+	// CssPath, FaviconPath, and TemplatePath are optional local files used to build the pages.
+	CssPath      string
+	FaviconPath  string
+	TemplatePath string
+	PageTitle    string
+	// CssUrl and FaviconUrl are the optional links to the css and favicon. Relative urls are treated as relative to
+	// the root of the site.
+	CssUrl     string
+	FaviconUrl string
+	// LiveReload injects a script into each page which reloads it when the content changes.
+	LiveReload bool
+	// History records every edit as a revision when set.
+	History *historyStore
+	// HtmlExtension adds '.html' to the routes of pages other than directory indexes, so that the links between pages
+	// keep working when the pages are exported as static files.
+	HtmlExtension bool
+}
+
+// SetAssets configures the css and favicon of the pages. Local files are served next to the pages under fixed names,
+// while remote urls are linked to directly.
+func (s *contentStore) SetAssets(cssUrl, faviconUrl string) {
+	s.CssUrl, s.FaviconUrl = cssUrl, faviconUrl
+	if cssPath, ok := localAssetPath(cssUrl); ok {
+		s.CssPath, s.CssUrl = cssPath, "default.css"
+	}
+	if faviconPath, ok := localAssetPath(faviconUrl); ok {
+		s.FaviconPath, s.FaviconUrl = faviconPath, "default-favicon"+filepath.Ext(faviconPath)
+	}
+}
+
+// loadResult is the outcome of an attempt to load the content.
+type loadResult struct {
+	Time time.Time
+	Err  error
+}
+
+// LastLoad returns the result of the most recent attempt to load the content, or nil if there has been none.
+func (s *contentStore) LastLoad() *loadResult {
+	return s.lastLoad.Load()
+}
+
+// Snapshot returns the snapshot currently being served.
+func (s *contentStore) Snapshot() *snapshot {
+	return s.current.Load()
+}
+
+// Paths returns the source files and directories that the current snapshot is built from. This may change between
+// loads when serving a directory.
+func (s *contentStore) Paths() []string {
+	paths := []string{s.MarkdownPath}
+	if snap := s.Snapshot(); snap != nil {
+		paths = snap.Sources
+	}
+	for _, p := range []string{s.CssPath, s.FaviconPath, s.TemplatePath} {
+		if p != "" {
+			paths = append(paths[:len(paths):len(paths)], p)
+		}
+	}
+	return paths
+}
+
+// Load reads all the source files and swaps in a new snapshot. On error, the previous snapshot (if any) continues to
+// be served.
+func (s *contentStore) Load() error {
+	s.loadLock.Lock()
+	defer s.loadLock.Unlock()
+	return s.load(context.Background())
+}
+
+// load is Load with the lock held. The span of the load is a child of the context, such as the request of an edit.
+func (s *contentStore) load(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "load content", trace.WithAttributes(attribute.String("md_http.path", s.MarkdownPath)))
+	defer func() {
+		endSpan(span, err)
+		s.lastLoad.Store(&loadResult{Time: time.Now(), Err: err})
+		s.loads.Add(1)
+		if err != nil {
+			s.loadFailures.Add(1)
+		}
+	}()
+	next := &snapshot{Pages: make(map[string]*page), Replaced: make(chan struct{}), LoadedAt: time.Now()}
+	if s.CssPath != "" {
+		slog.Debug("reading css file", "path", s.CssPath)
+		raw, err := os.ReadFile(s.CssPath)
+		if err != nil {
+			return fmt.Errorf("failed to read the css file: %v", err)
+		}
+		next.Css = raw
+		next.CssVariant = compressible(newRepresentation("text/css; charset=utf-8", raw))
+	}
+	if s.FaviconPath != "" {
+		slog.Debug("reading favicon file", "path", s.FaviconPath)
+		raw, err := os.ReadFile(s.FaviconPath)
+		if err != nil {
+			return fmt.Errorf("failed to read the favicon file: %v", err)
+		}
+		next.Favicon = raw
+	}
+	tmpl, err := loadTemplate(s.TemplatePath)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.MarkdownPath)
+	if err != nil {
+		return fmt.Errorf("failed to open the file: %w", err)
+	}
+	sources := map[string]string{"": s.MarkdownPath}
+	if info.IsDir() {
+		if sources, next.Sources, err = findMarkdownFiles(s.MarkdownPath); err != nil {
+			return err
+		}
+	} else {
+		next.Sources = []string{s.MarkdownPath}
+	}
+
+	// work out all the routes first so that links between the documents can be resolved while rendering
+	routes := make(map[string]string, len(sources))
+	for rel := range sources {
+		route := routeForSource(rel)
+		if s.HtmlExtension && !strings.HasSuffix(route, "/") {
+			route += ".html"
+		}
+		if other, ok := routes[route]; ok && !isPreferredIndex(rel, other) {
+			continue
+		}
+		routes[route] = rel
+	}
+	routeBySource := make(map[string]string, len(routes))
+	for route, rel := range routes {
+		routeBySource[rel] = route
+	}
+
+	raws := make(map[string][]byte, len(routes))
+	bodies := make(map[string][]byte, len(routes))
+	frontMatters := make(map[string]frontMatter, len(routes))
+	labels := make(map[string]string, len(routes))
+	for route, rel := range routes {
+		slog.Debug("reading markdown file", "path", sources[rel])
+		raw, err := os.ReadFile(sources[rel])
+		if err != nil {
+			return fmt.Errorf("failed to open the file: %w", err)
+		}
+		fm, body, err := splitFrontMatter(raw)
+		if err != nil {
+			slog.Warn("rendering the whole file as markdown", "path", sources[rel], "err", err)
+		}
+		raws[route], bodies[route], frontMatters[route] = raw, body, fm
+		if labels[route] = fm.Title; labels[route] == "" {
+			if labels[route] = firstHeading(body); labels[route] == "" && s.HtmlExtension {
+				labels[route] = fallbackLabel(strings.TrimSuffix(route, ".html"))
+			} else if labels[route] == "" {
+				labels[route] = fallbackLabel(route)
+			}
+		}
+	}
+
+	// when serving more than one document, each page gets navigation
+	var nav *navNode
+	if info.IsDir() {
+		nav = buildNav(labels)
+	}
+
+	// templates that show the user are rendered again for each authenticated request
+	personal := templateUsesField(tmpl, "User")
+
+	// renderPage converts a single document into all the variants of its page
+	renderPage := func(route, rel string) (*page, error) {
+		slog.Debug("converting markdown to html", "route", route)
+		rewrite := func(link string) string {
+			return rewriteMarkdownLink(link, rel, route, routeBySource)
+		}
+		body, toc, err := renderMarkdown(bodies[route], rewrite)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render '%s': %w", sources[rel], err)
+		}
+		text, err := renderTerminal(bodies[route], rewrite, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render '%s': %w", sources[rel], err)
+		}
+		ansiText, err := renderTerminal(bodies[route], rewrite, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render '%s': %w", sources[rel], err)
+		}
+		fm := frontMatters[route]
+		data := templateData{
+			Title:           s.PageTitle,
+			SiteTitle:       s.PageTitle,
+			Route:           route,
+			Description:     fm.Description,
+			Lang:            fm.Lang,
+			CssUrl:          siteRelativeUrl(route, s.CssUrl),
+			FaviconUrl:      siteRelativeUrl(route, s.FaviconUrl),
+			Body:            template.HTML(body),
+			TableOfContents: template.HTML(toc),
+			Meta:            fm.Meta,
+		}
+		if nav != nil {
+			data.Title = labels[route] + " - " + s.PageTitle
+			navForPage := nav.ForPage(route)
+			data.Nav, data.Breadcrumbs = &navForPage, nav.Breadcrumbs(route)
+		}
+		// the front matter takes precedence over the options, and links in it are relative to the page
+		if fm.Title != "" {
+			data.Title = fm.Title
+		}
+		if fm.Css != "" {
+			data.CssUrl = fm.Css
+		}
+		if fm.Favicon != "" {
+			data.FaviconUrl = fm.Favicon
+		}
+		renderHtml := func(data templateData) ([]byte, error) {
+			buffer := new(bytes.Buffer)
+			if err := tmpl.Execute(buffer, data); err != nil {
+				return nil, fmt.Errorf("failed to render the template for '%s': %w", sources[rel], err)
+			}
+			if s.LiveReload {
+				return injectLiveReloadScript(buffer.Bytes(), relativeRoot(route)+LiveReloadPath[1:]), nil
+			}
+			return buffer.Bytes(), nil
+		}
+		htmlContent, err := renderHtml(data)
+		if err != nil {
+			return nil, err
+		}
+		p := &page{
+			Route:  route,
+			Source: rel,
+			Path:   sources[rel],
+			Raw:    raws[route],
+			Html:   htmlContent,
+			Hash:   fmt.Sprintf("%x", sha256.Sum256(htmlContent)),
+		}
+		p.Variants = map[string]representation{
+			"text/html":     {ContentType: "text/html; charset=utf-8", Body: p.Html, Hash: p.Hash},
+			"text/markdown": newRepresentation("text/markdown; charset=utf-8", p.Raw),
+			"text/plain":    newRepresentation("text/plain; charset=utf-8", text),
+			ansiVariant:     newRepresentation("text/plain; charset=utf-8", ansiText),
+		}
+		// the variants don't change until the next render, so each is compressed at most once rather than per request
+		for mediaType, rep := range p.Variants {
+			p.Variants[mediaType] = compressible(rep)
+		}
+		if personal {
+			p.Personalize = func(user *identity) (representation, error) {
+				personalData := data
+				personalData.User = user
+				body, err := renderHtml(personalData)
+				if err != nil {
+					return representation{}, err
+				}
+				return newRepresentation("text/html; charset=utf-8", body), nil
+			}
+		}
+		return p, nil
+	}
+	for route, rel := range routes {
+		_, renderSpan := tracer.Start(ctx, "render page", trace.WithAttributes(attribute.String("md_http.route", route)))
+		p, err := renderPage(route, rel)
+		endSpan(renderSpan, err)
+		if err != nil {
+			return err
+		}
+		next.Pages[route] = p
+	}
+	if !info.IsDir() {
+		next.Fallback = next.Pages["/"]
+	} else if len(next.Pages) == 0 {
+		return fmt.Errorf("no markdown files found in '%s'", s.MarkdownPath)
+	}
+
+	hash := sha256.New()
+	for _, route := range sortedKeys(next.Pages) {
+		_, _ = fmt.Fprintf(hash, "%s %s\n", route, next.Pages[route].Hash)
+	}
+	_, _ = fmt.Fprintf(hash, "css %x\nfavicon %x\n", sha256.Sum256(next.Css), sha256.Sum256(next.Favicon))
+	next.Hash = fmt.Sprintf("%x", hash.Sum(nil))
+
+	if old := s.current.Swap(next); old != nil {
+		close(old.Replaced)
+		slog.Info("reloaded content", "path", s.MarkdownPath, "pages", len(next.Pages))
+	}
+	span.SetAttributes(attribute.Int("md_http.pages", len(next.Pages)), attribute.String("md_http.hash", next.Hash))
+	return nil
+}
+
+// Reload is Load but logs the error rather than returning it, so that it can be used as a callback.
+func (s *contentStore) Reload() {
+	if err := s.Load(); err != nil {
+		slog.Error("failed to reload content, continuing to serve the previous version", "err", err)
+	}
+}
+
+// sortedKeys returns the keys of the map in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// findMarkdownFiles walks the directory and returns the markdown files by their slash separated relative path, along
+// with the list of directories and files to watch for changes. Hidden files and directories are skipped, this also
+// skips the timestamped data directories in Kubernetes ConfigMap volumes while still following the symlinks to them.
+func findMarkdownFiles(dir string) (map[string]string, []string, error) {
+	files := make(map[string]string)
+	var watched []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			watched = append(watched, p)
+			return nil
+		}
+		if filepath.Ext(d.Name()) != ".md" {
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+				return nil
+			}
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = p
+		watched = append(watched, p)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list markdown files: %w", err)
+	}
+	return files, watched, nil
+}
+
+// routeForSource converts a relative markdown path into the route it is served on. For example 'foo/bar.md' becomes
+// '/foo/bar' and 'foo/index.md' or 'foo/README.md' become the directory index '/foo/'. The empty path is used for a
+// single served file and maps to the root.
+func routeForSource(rel string) string {
+	dir, name := path.Split(rel)
+	switch name {
+	case "", "index.md", "README.md":
+		return "/" + dir
+	}
+	return "/" + dir + strings.TrimSuffix(name, ".md")
+}
+
+// isPreferredIndex returns true if the candidate should be used over the existing source for the same route. This
+// happens when a directory contains both an index.md and a README.md, in which case index.md wins.
+func isPreferredIndex(candidate, existing string) bool {
+	return path.Base(candidate) == "index.md" && path.Base(existing) != "index.md"
+}
+
+// relativeRoot returns the relative url prefix that leads from the given route back to the root of the site. Using
+// relative links means the site keeps working when served under a path prefix by a proxy.
+func relativeRoot(route string) string {
+	dir := route[:strings.LastIndex(route, "/")+1]
+	return strings.Repeat("../", strings.Count(dir, "/")-1)
+}
+
+// isAbsoluteUrl returns true if the url has a scheme or is rooted at the host.
+func isAbsoluteUrl(raw string) bool {
+	if strings.HasPrefix(raw, "/") {
+		return true
+	}
+	u, err := url.Parse(raw)
+	return err != nil || u.Scheme != ""
+}
+
+// rewriteMarkdownLink converts a relative link to another markdown document into a relative link to the route that
+// document is served on. The source and route are the markdown file containing the link and the route it is served
+// on. Links to anything else are returned unchanged.
+func rewriteMarkdownLink(link, source, route string, routeBySource map[string]string) string {
+	if link == "" || strings.HasPrefix(link, "#") || isAbsoluteUrl(link) {
+		return link
+	}
+	target, suffix := link, ""
+	if i := strings.IndexAny(link, "?#"); i >= 0 {
+		target, suffix = link[:i], link[i:]
+	}
+	if path.Ext(target) != ".md" {
+		return link
+	}
+	targetRoute, ok := routeBySource[path.Join(path.Dir(source), target)]
+	if !ok {
+		return link
+	}
+	return relativeLink(route, targetRoute) + suffix
+}
+
+// linkRewritingRenderer is a blackfriday renderer that passes the destination of every link through a function
+// before rendering it.
+type linkRewritingRenderer struct {
+	blackfriday.Renderer
+	rewrite func(string) string
+}
+
+func (r *linkRewritingRenderer) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	r.Renderer.Link(out, []byte(r.rewrite(string(link))), title, content)
+}
+
+// localAssetPath returns the local file path for a css or favicon option, or false if it is a remote url.
+func localAssetPath(url string) (string, bool) {
+	if url == "" || strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return "", false
+	}
+	return strings.TrimPrefix(url, "file://"), true
+}
+
+// siteRelativeUrl converts a url which is relative to the root of the site into one that is relative to the given
+// route. Absolute urls are returned unchanged.
+func siteRelativeUrl(route, raw string) string {
+	if raw == "" || isAbsoluteUrl(raw) {
+		return raw
+	}
+	return relativeRoot(route) + raw
+}
+
+// markdownExtensions are the markdown syntax extensions shared by every renderer.
+const markdownExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+	blackfriday.EXTENSION_TABLES |
+	blackfriday.EXTENSION_FENCED_CODE |
+	blackfriday.EXTENSION_AUTOLINK |
+	blackfriday.EXTENSION_STRIKETHROUGH |
+	blackfriday.EXTENSION_SPACE_HEADERS |
+	blackfriday.EXTENSION_HEADER_IDS |
+	blackfriday.EXTENSION_BACKSLASH_LINE_BREAK |
+	blackfriday.EXTENSION_DEFINITION_LISTS |
+	// extras
+	blackfriday.EXTENSION_FOOTNOTES |
+	blackfriday.EXTENSION_AUTO_HEADER_IDS
+
+// renderMarkdown converts the raw markdown into the html body content and a table of contents. The table of contents
+// is empty if there are no headings. The rewriteLink function is applied to the destination of every link in the
+// document.
+func renderMarkdown(raw []byte, rewriteLink func(string) string) (body []byte, toc []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to render markdown: %v", r)
+		}
+	}()
+	render := func(extraFlags int) []byte {
+		return blackfriday.Markdown(
+			raw,
+			&linkRewritingRenderer{
+				Renderer: blackfriday.HtmlRenderer(
+					// common defaults
+					blackfriday.HTML_USE_XHTML|
+						blackfriday.HTML_USE_SMARTYPANTS|
+						blackfriday.HTML_SMARTYPANTS_FRACTIONS|
+						blackfriday.HTML_SMARTYPANTS_DASHES|
+						blackfriday.HTML_SMARTYPANTS_LATEX_DASHES|
+						// extras
+						blackfriday.HTML_FOOTNOTE_RETURN_LINKS|
+						blackfriday.HTML_HREF_TARGET_BLANK|
+						extraFlags,
+					"",
+					"",
+				),
+				rewrite: rewriteLink,
+			},
+			markdownExtensions,
+		)
+	}
+	body = render(0)
+	// the table of contents is rendered separately, with the same options so that the heading ids match the body
+	toc = render(blackfriday.HTML_TOC | blackfriday.HTML_OMIT_CONTENTS)
+	if bytes.Equal(bytes.TrimSpace(toc), []byte("<nav>\n</nav>")) {
+		toc = nil
+	}
+	return body, toc, nil
+}
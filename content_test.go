@@ -0,0 +1,181 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteForSource(t *testing.T) {
+	for source, route := range map[string]string{
+		"":                "/",
+		"index.md":        "/",
+		"README.md":       "/",
+		"foo.md":          "/foo",
+		"foo/bar.md":      "/foo/bar",
+		"foo/index.md":    "/foo/",
+		"foo/README.md":   "/foo/",
+		"foo/bar/baz.md":  "/foo/bar/baz",
+		"foo/readme.md":   "/foo/readme",
+		"foo/bar.name.md": "/foo/bar.name",
+	} {
+		assert.Equal(t, route, routeForSource(source), source)
+	}
+}
+
+func TestRelativeRoot(t *testing.T) {
+	for route, root := range map[string]string{
+		"/":            "",
+		"/foo":         "",
+		"/foo/":        "../",
+		"/foo/bar":     "../",
+		"/foo/bar/":    "../../",
+		"/foo/bar/baz": "../../",
+	} {
+		assert.Equal(t, root, relativeRoot(route), route)
+	}
+}
+
+func TestRewriteMarkdownLink(t *testing.T) {
+	routeBySource := map[string]string{
+		"index.md":       "/",
+		"foo.md":         "/foo",
+		"sub/README.md":  "/sub/",
+		"sub/thing.md":   "/sub/thing",
+		"sub/deep/x.md":  "/sub/deep/x",
+		"other/index.md": "/other/",
+	}
+	for _, tc := range []struct {
+		source, link, expected string
+	}{
+		{"index.md", "foo.md", "./foo"},
+		{"index.md", "./foo.md#section", "./foo#section"},
+		{"index.md", "sub/README.md", "./sub/"},
+		{"index.md", "sub/thing.md?x=y", "./sub/thing?x=y"},
+		{"sub/thing.md", "../foo.md", "../foo"},
+		{"sub/thing.md", "README.md", "../sub/"},
+		{"sub/thing.md", "deep/x.md", "../sub/deep/x"},
+		{"sub/deep/x.md", "../../index.md", "../../"},
+		{"sub/deep/x.md", "../../other/index.md", "../../other/"},
+		{"index.md", "missing.md", "missing.md"},
+		{"index.md", "#anchor", "#anchor"},
+		{"index.md", "https://example.com/foo.md", "https://example.com/foo.md"},
+		{"index.md", "/foo.md", "/foo.md"},
+		{"index.md", "image.png", "image.png"},
+	} {
+		assert.Equal(t, tc.expected, rewriteMarkdownLink(tc.link, tc.source, routeBySource[tc.source], routeBySource), tc)
+	}
+}
+
+func TestContentStore_directory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0700))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".hidden"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("# readme\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("# index\n\n[thing](sub/thing.md)\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "thing.md"), []byte("# thing\n\n[home](../index.md)\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "notes.txt"), []byte("not markdown"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden", "secret.md"), []byte("# secret\n"), 0600))
+
+	store := &contentStore{MarkdownPath: dir, PageTitle: "title", CssUrl: "default.css"}
+	require.NoError(t, store.Load())
+	snap := store.Snapshot()
+	assert.Nil(t, snap.Fallback)
+	assert.ElementsMatch(t, []string{"/", "/sub/thing"}, keys(snap.Pages))
+	assert.ElementsMatch(t, []string{dir, filepath.Join(dir, "README.md"), filepath.Join(dir, "index.md"), filepath.Join(dir, "sub"), filepath.Join(dir, "sub", "thing.md")}, snap.Sources)
+
+	assert.Equal(t, "index.md", snap.Pages["/"].Source)
+	assert.Contains(t, string(snap.Pages["/"].Html), `<a href="./sub/thing">thing</a>`)
+	assert.Contains(t, string(snap.Pages["/"].Html), `href="default.css"`)
+	assert.Contains(t, string(snap.Pages["/sub/thing"].Html), `<a href="../">home</a>`)
+	assert.Contains(t, string(snap.Pages["/sub/thing"].Html), `href="../default.css"`)
+	assert.NotEqual(t, snap.Pages["/"].Hash, snap.Pages["/sub/thing"].Hash)
+}
+
+func keys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestContentStore_directoryLayout(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "guides"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("# Welcome\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "guides", "setup.md"), []byte("Setup <Guide>\n===\n\ntext\n"), 0600))
+
+	store := &contentStore{MarkdownPath: dir, PageTitle: "Site"}
+	require.NoError(t, store.Load())
+	html := string(store.Snapshot().Pages["/guides/setup"].Html)
+	assert.Contains(t, html, "<!DOCTYPE html>")
+	assert.Contains(t, html, "<title>Setup &lt;Guide&gt; - Site</title>")
+	assert.Contains(t, html, `<li><a href="../">Welcome</a></li>`)
+	assert.Contains(t, html, `<li>guides</li>`)
+	assert.Contains(t, html, `<strong aria-current="page">Setup &lt;Guide&gt;</strong>`)
+	assert.Contains(t, html, "<p>text</p>")
+	assert.NotContains(t, html, "stylesheet")
+}
+
+func TestContentStore_badTemplate(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	templatePath := filepath.Join(t.TempDir(), "page.html")
+	require.NoError(t, os.WriteFile(templatePath, []byte(`{{ .Body }}`), 0600))
+
+	store := &contentStore{MarkdownPath: mdPath, TemplatePath: templatePath}
+	require.NoError(t, store.Load())
+	assert.Equal(t, "<h1 id=\"example\">example</h1>\n", string(store.Snapshot().Pages["/"].Html))
+
+	// a broken template keeps the previous version
+	require.NoError(t, os.WriteFile(templatePath, []byte(`{{ .Body `), 0600))
+	assert.ErrorContains(t, store.Load(), "failed to parse the template file")
+	require.NoError(t, os.WriteFile(templatePath, []byte(`{{ .Missing }}`), 0600))
+	assert.ErrorContains(t, store.Load(), "failed to render the template")
+	assert.Equal(t, "<h1 id=\"example\">example</h1>\n", string(store.Snapshot().Pages["/"].Html))
+}
+
+func TestRenderMarkdown_noHeadings(t *testing.T) {
+	body, toc, err := renderMarkdown([]byte("just text\n"), func(s string) string { return s })
+	assert.NoError(t, err)
+	assert.Equal(t, "<p>just text</p>\n", string(body))
+	assert.Empty(t, toc)
+}
+
+func TestTemplateUsesField(t *testing.T) {
+	for source, expected := range map[string]bool{
+		`{{ .Body }}`:      false,
+		`{{ .User.Name }}`: true,
+		`{{ if .User }}hi {{ .User.Name }}{{ end }}`:      true,
+		`{{ range .Breadcrumbs }}{{ $.User }}{{ end }}`:   true,
+		`{{ define "x" }}{{ .User }}{{ end }}{{ .Body }}`: true,
+		`{{ with .Meta }}{{ .User }}{{ end }}`:            true,
+		`{{ printf "%s" .Title }}`:                        false,
+	} {
+		tmpl, err := template.New("").Parse(source)
+		require.NoError(t, err)
+		assert.Equal(t, expected, templateUsesField(tmpl, "User"), source)
+	}
+}
+
+func TestContentStore_personalize(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	templatePath := filepath.Join(t.TempDir(), "page.html")
+	require.NoError(t, os.WriteFile(templatePath, []byte(`{{ with .User }}{{ .Name }}:{{ end }}{{ .Body }}`), 0600))
+
+	store := &contentStore{MarkdownPath: mdPath, TemplatePath: templatePath}
+	require.NoError(t, store.Load())
+	p := store.Snapshot().Pages["/"]
+	assert.Equal(t, "<h1 id=\"example\">example</h1>\n", string(p.Html))
+	require.NotNil(t, p.Personalize)
+	rep, err := p.Personalize(&identity{Name: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, "alice:<h1 id=\"example\">example</h1>\n", string(rep.Body))
+	assert.NotEqual(t, p.Hash, rep.Hash)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// EditPath is the route of the in-browser editor.
+const EditPath = "/_edit"
+
+// DefaultMaxEditBytes is the largest markdown document accepted by an update.
+const DefaultMaxEditBytes = 10 << 20
+
+var (
+	// errPreconditionFailed is returned when an update is based on a version of the page that is no longer current.
+	errPreconditionFailed = errors.New("the page has changed since it was loaded")
+)
+
+// Update replaces the markdown file of the page on the given route, but only if the page currently has the expected
+// etag. The file is replaced atomically and the content is reloaded, as part of the context, before returning the new
+// version of the page. When a history store is configured, the change is recorded as a revision by the given author.
+func (s *contentStore) Update(ctx context.Context, route, ifMatch string, raw []byte, author string) (*page, error) {
+	s.loadLock.Lock()
+	defer s.loadLock.Unlock()
+
+	current, ok := s.Snapshot().Pages[route]
+	if !ok {
+		return nil, fmt.Errorf("no page on route '%s'", route)
+	} else if current.Hash != etagHash(ifMatch) {
+		return nil, errPreconditionFailed
+	}
+	if _, _, err := splitFrontMatter(raw); err != nil {
+		// the load renders it like any other file with a broken front matter
+		slog.Warn("rendering the whole file as markdown", "route", route, "err", err)
+	}
+	if s.History != nil {
+		if err := s.History.Ensure(route, current.Raw); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeFileAtomic(current.Path, raw); err != nil {
+		return nil, err
+	}
+	slog.Info("updated markdown file", "path", current.Path, "route", route)
+	if s.History != nil {
+		// the file has already been replaced, so a failure here should not fail the update
+		if _, err := s.History.Record(route, raw, author); err != nil {
+			slog.Error("failed to record revision", "route", route, "err", err)
+		}
+	}
+	if err := s.load(ctx); err != nil {
+		return nil, err
+	}
+	return s.Snapshot().Pages[route], nil
+}
+
+// writeFileAtomic replaces the file by writing to a temporary file in the same directory and renaming it over the
+// original, so that readers never see a partially written file. Symlinks are resolved so that the link is kept.
+func writeFileAtomic(path string, data []byte) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return nil
+}
+
+// writeUpdateError converts an error from contentStore.Update into a response.
+func writeUpdateError(writer http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errPreconditionFailed):
+		http.Error(writer, err.Error(), http.StatusPreconditionFailed)
+	case errors.As(err, &maxBytesErr):
+		http.Error(writer, "the content is too large", http.StatusRequestEntityTooLarge)
+	default:
+		slog.Error("failed to update page", "err", err)
+		http.Error(writer, "failed to update the page", http.StatusInternalServerError)
+	}
+}
+
+// putPage handles a PUT of new markdown to the route of an existing page. The If-Match header must hold the current
+// etag of the page and the new etag is returned on success.
+func putPage(content *contentStore, current *page, writer http.ResponseWriter, request *http.Request) {
+	ifMatch := request.Header.Get("If-Match")
+	if ifMatch == "" {
+		http.Error(writer, "the If-Match header is required", http.StatusPreconditionRequired)
+		return
+	}
+	raw, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, DefaultMaxEditBytes))
+	if err != nil {
+		writeUpdateError(writer, err)
+		return
+	}
+	updated, err := content.Update(request.Context(), current.Route, ifMatch, raw, requestAuthor(request))
+	if err != nil {
+		writeUpdateError(writer, err)
+		return
+	}
+	writer.Header().Set("Etag", updated.Hash)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// crossSite reports whether a form post was sent from another site, such as a hostile page that makes the browser
+// submit it with the basic auth or client certificate of the editor. The etag in the form is no defence since anyone
+// who can read the page knows it. Browsers send Sec-Fetch-Site, or at least Origin, with form posts, while other
+// clients send neither and are let through.
+func crossSite(request *http.Request) bool {
+	if site := request.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site != "same-origin"
+	}
+	if origin := request.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != request.Host
+	}
+	return false
+}
+
+// editData is the input to the editor template.
+type editData struct {
+	Route   string
+	PageUrl string
+	CssUrl  string
+	Etag    string
+	Raw     string
+}
+
+var editTemplate = template.Must(template.New("edit").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>Editing {{ .Route }}</title>
+  {{- if .CssUrl }}
+  <link rel="stylesheet" type="text/css" href="{{ .CssUrl }}">
+  {{- end }}
+  <style>
+    .md-http-editor textarea { box-sizing: border-box; width: 100%; height: 70vh; font-family: monospace; }
+  </style>
+</head>
+<body>
+<h1>Editing <a href="{{ .PageUrl }}">{{ .Route }}</a></h1>
+<form class="md-http-editor" method="post">
+  <input type="hidden" name="page" value="{{ .Route }}">
+  <input type="hidden" name="etag" value="{{ .Etag }}">
+  <textarea name="content" spellcheck="false">{{ .Raw }}</textarea>
+  <button type="submit">Save</button>
+</form>
+</body>
+</html>
+`))
+
+// editHandler serves the editor for the page given by the 'page' query parameter on GET, and accepts the submitted
+// form on POST. The form carries the etag of the page it was loaded from, so concurrent edits are rejected.
+func editHandler(content *contentStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		route := request.URL.Query().Get("page")
+		if request.Method == "POST" {
+			if crossSite(request) {
+				http.Error(writer, "cross-site requests are not allowed", http.StatusForbidden)
+				return
+			}
+			request.Body = http.MaxBytesReader(writer, request.Body, DefaultMaxEditBytes)
+			if err := request.ParseForm(); err != nil {
+				writeUpdateError(writer, err)
+				return
+			}
+			route = request.PostForm.Get("page")
+		}
+		if route == "" {
+			route = "/"
+		}
+		current, ok := content.Snapshot().Pages[route]
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+
+		switch request.Method {
+		case "GET":
+			writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+			writer.Header().Set("Cache-Control", "no-store")
+			if err := editTemplate.Execute(writer, editData{
+				Route:   current.Route,
+				PageUrl: relativeLink(EditPath, current.Route),
+				CssUrl:  siteRelativeUrl(EditPath, content.CssUrl),
+				Etag:    current.Hash,
+				Raw:     string(current.Raw),
+			}); err != nil {
+				slog.Error("failed to render the editor", "err", err)
+			}
+		case "POST":
+			raw := []byte(request.PostForm.Get("content"))
+			// browsers submit textarea content with CRLF line endings, keep the line endings of the original file
+			if !bytes.Contains(current.Raw, []byte("\r\n")) {
+				raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+			}
+			updated, err := content.Update(request.Context(), current.Route, request.PostForm.Get("etag"), raw, requestAuthor(request))
+			if err != nil {
+				writeUpdateError(writer, err)
+				return
+			}
+			writer.Header().Set("Etag", updated.Hash)
+			http.Redirect(writer, request, relativeLink(EditPath, updated.Route), http.StatusSeeOther)
+		default:
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "target.md"), []byte("a"), 0640))
+	require.NoError(t, os.Symlink("target.md", filepath.Join(dir, "link.md")))
+
+	require.NoError(t, writeFileAtomic(filepath.Join(dir, "link.md"), []byte("b")))
+	raw, err := os.ReadFile(filepath.Join(dir, "target.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(raw))
+	info, err := os.Lstat(filepath.Join(dir, "link.md"))
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeSymlink)
+	info, err = os.Stat(filepath.Join(dir, "target.md"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestRunEdit_put(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# first\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, Edit: true}, nil)
+
+	resp, _ := getBody(t, baseUrl+"/")
+	etag := resp.Header.Get("Etag")
+
+	put := func(ifMatch, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, baseUrl+"/", strings.NewReader(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	assert.Equal(t, http.StatusPreconditionRequired, put("", "# nope\n").StatusCode)
+	assert.Equal(t, http.StatusPreconditionFailed, put("unknown", "# nope\n").StatusCode)
+
+	// a leading horizontal rule without a closing delimiter is not front matter, so the whole file is markdown
+	resp = put(etag, "---\nsome text\n")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	etag = resp.Header.Get("Etag")
+	resp, body := getBody(t, baseUrl+"/")
+	assert.Equal(t, etag, resp.Header.Get("Etag"))
+	assert.Contains(t, body, "<hr")
+	assert.Contains(t, body, "some text")
+
+	resp = put(etag, "# second\n")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	newEtag := resp.Header.Get("Etag")
+	assert.NotEqual(t, etag, newEtag)
+
+	raw, err := os.ReadFile(mdPath)
+	require.NoError(t, err)
+	assert.Equal(t, "# second\n", string(raw))
+	resp, body = getBody(t, baseUrl+"/")
+	assert.Equal(t, newEtag, resp.Header.Get("Etag"))
+	assert.Contains(t, body, ">second</h1>")
+
+	// the old etag can no longer be used
+	assert.Equal(t, http.StatusPreconditionFailed, put(etag, "# third\n").StatusCode)
+}
+
+func TestRunEdit_form(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("# index\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "page.md"), []byte("# <page>\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: dir, Edit: true}, nil)
+
+	resp, _ := getBody(t, baseUrl+"/sub/page")
+	etag := resp.Header.Get("Etag")
+
+	resp, body := getBody(t, baseUrl+"/_edit?page=/sub/page")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `<textarea name="content" spellcheck="false"># &lt;page&gt;`)
+	assert.Contains(t, body, `<input type="hidden" name="etag" value="`+etagHash(etag)+`">`)
+	assert.Contains(t, body, `<a href="./sub/page">/sub/page</a>`)
+
+	resp, _ = getBody(t, baseUrl+"/_edit?page=/missing")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	post := func(form url.Values, headers map[string]string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, baseUrl+"/_edit", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+
+	// forms posted from other sites are refused even with the right etag
+	form := url.Values{"page": {"/sub/page"}, "etag": {etag}, "content": {"# forged\n"}}
+	assert.Equal(t, http.StatusForbidden, post(form, map[string]string{"Sec-Fetch-Site": "cross-site"}).StatusCode)
+	assert.Equal(t, http.StatusForbidden, post(form, map[string]string{"Origin": "https://evil.example"}).StatusCode)
+	raw, err := os.ReadFile(filepath.Join(dir, "sub", "page.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "# <page>\n", string(raw))
+
+	resp = post(url.Values{"page": {"/sub/page"}, "etag": {etag}, "content": {"# edited\r\n\r\ntext\r\n"}}, map[string]string{
+		"Sec-Fetch-Site": "same-origin",
+		"Origin":         baseUrl,
+	})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/sub/page", resp.Header.Get("Location"))
+	raw, err = os.ReadFile(filepath.Join(dir, "sub", "page.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "# edited\n\ntext\n", string(raw))
+
+	resp, err = client.PostForm(baseUrl+"/_edit", url.Values{"page": {"/sub/page"}, "etag": {etag}, "content": {"# again"}})
+	require.NoError(t, err)
+	data, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, "the page has changed since it was loaded\n", string(data))
+}
+
+func TestCrossSite(t *testing.T) {
+	for headers, expected := range map[[2]string]bool{
+		{"", ""}:                                 false,
+		{"same-origin", ""}:                      false,
+		{"same-origin", "https://evil.example"}:  false,
+		{"same-site", ""}:                        true,
+		{"cross-site", "http://docs.example"}:    true,
+		{"none", ""}:                             true,
+		{"", "http://docs.example"}:              false,
+		{"", "https://docs.example"}:             false,
+		{"", "http://evil.example"}:              true,
+		{"", "http://docs.example.evil.example"}: true,
+		{"", "null"}:                             true,
+	} {
+		request := &http.Request{Host: "docs.example", Header: http.Header{}}
+		if headers[0] != "" {
+			request.Header.Set("Sec-Fetch-Site", headers[0])
+		}
+		if headers[1] != "" {
+			request.Header.Set("Origin", headers[1])
+		}
+		assert.Equal(t, expected, crossSite(request), headers)
+	}
+}
+
+func TestRunEdit_disabled(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# first\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath}, nil)
+
+	req, _ := http.NewRequest(http.MethodPut, baseUrl+"/", strings.NewReader("# x\n"))
+	req.Header.Set("If-Match", "x")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
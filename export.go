@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/russross/blackfriday"
+)
+
+const (
+	// DefaultExportOut writes the html of a single page to stdout rather than to a directory.
+	DefaultExportOut         = "-"
+	DefaultExportUsagePrefix = `Usage: md-http export [options...] <filepath or directory>
+
+Renders the pages exactly as they are served and writes them as static files. Each page is written as an html file
+along with the local css and favicon and any local images or files the markdown links to.
+`
+)
+
+type exportArgsStruct struct {
+	MarkdownFile string
+	Out          string
+	PageTitle    string
+	CssUrl       string
+	FaviconUrl   string
+	TemplateFile string
+	LogDebug     bool
+	LogJson      bool
+}
+
+// mainExport is the entrypoint of the export subcommand. Logs are written to stderr so that the html can be written
+// to the output.
+func mainExport(args []string, output io.Writer) error {
+	parsedArgs, err := parseExport(args, output)
+	if err != nil {
+		return err
+	}
+	setupLogging(os.Stderr, parsedArgs.LogDebug, parsedArgs.LogJson)
+	return export(parsedArgs, output)
+}
+
+func parseExport(args []string, output io.Writer) (exportArgsStruct, error) {
+	fs := flag.NewFlagSet(filepath.Base(args[0]), flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	receiver := new(exportArgsStruct)
+	fs.StringVar(&receiver.Out, "out", DefaultExportOut, "The directory to write the files to, or '-' to write the html of a single page to stdout")
+	fs.StringVar(&receiver.PageTitle, "title", DefaultPageTitle, "The HTML title of the page")
+	fs.StringVar(&receiver.CssUrl, "css", DefaultCssUrl, "An optional css file path or url (http:// or https://) to include in the output")
+	fs.StringVar(&receiver.FaviconUrl, "favicon", DefaultFaviconUrl, "An optional favicon file path or url (http:// or https://) to include with the output")
+	fs.StringVar(&receiver.TemplateFile, "template", DefaultTemplateFile, "An optional html/template file path used to render the page instead of the built-in template")
+	fs.BoolVar(&receiver.LogDebug, "debug", DefaultDebug, "Enable debug logging")
+	fs.BoolVar(&receiver.LogJson, "jsonlog", false, "Switch to structured json logging")
+
+	fs.Usage = func() {
+		_, _ = fs.Output().Write([]byte(DefaultExportUsagePrefix))
+		fs.PrintDefaults()
+		_, _ = fs.Output().Write([]byte(DefaultUsageSuffix))
+	}
+	if err := setFlagsFromEnv(fs); err != nil {
+		return *receiver, err
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return *receiver, http.ErrServerClosed
+		}
+		return *receiver, err
+	}
+	if fs.NArg() != 1 {
+		_, _ = fs.Output().Write([]byte("Expected a single argument as the markdown filepath or directory!\n\n"))
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	receiver.MarkdownFile = fs.Arg(0)
+	return *receiver, nil
+}
+
+// export renders the content the same way as run and writes it to the output directory, or the html of the single page
+// to the output.
+func export(parsedArgs exportArgsStruct, output io.Writer) error {
+	toStdout := parsedArgs.Out == "-"
+	content := &contentStore{
+		MarkdownPath:  parsedArgs.MarkdownFile,
+		TemplatePath:  parsedArgs.TemplateFile,
+		PageTitle:     parsedArgs.PageTitle,
+		HtmlExtension: !toStdout,
+	}
+	content.SetAssets(parsedArgs.CssUrl, parsedArgs.FaviconUrl)
+	if err := content.Load(); err != nil {
+		return err
+	}
+	snap := content.Snapshot()
+
+	if toStdout {
+		if len(snap.Pages) != 1 {
+			return fmt.Errorf("can only write a single page to stdout but found %d, use -out to write to a directory", len(snap.Pages))
+		}
+		for _, p := range snap.Pages {
+			_, err := output.Write(p.Html)
+			return err
+		}
+	}
+
+	files := make(map[string][]byte)
+	if content.CssPath != "" {
+		files[content.CssUrl] = snap.Css
+	}
+	if content.FaviconPath != "" {
+		files[content.FaviconUrl] = snap.Favicon
+	}
+	root := content.MarkdownPath
+	if snap.Fallback != nil {
+		root = filepath.Dir(content.MarkdownPath)
+	}
+	for route, p := range snap.Pages {
+		name := strings.TrimPrefix(route, "/")
+		if name == "" || strings.HasSuffix(name, "/") {
+			name += "index.html"
+		}
+		files[name] = p.Html
+		assets, err := localAssets(p)
+		if err != nil {
+			return fmt.Errorf("failed to export '%s': %w", p.Path, err)
+		}
+		for _, asset := range assets {
+			if _, ok := files[asset]; ok {
+				continue
+			}
+			raw, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(asset)))
+			if err != nil {
+				return fmt.Errorf("failed to read a file linked from '%s': %w", p.Path, err)
+			}
+			files[asset] = raw
+		}
+	}
+
+	for name, raw := range files {
+		target := filepath.Join(parsedArgs.Out, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create the output directory: %w", err)
+		}
+		if err := os.WriteFile(target, raw, 0644); err != nil {
+			return fmt.Errorf("failed to write the output file: %w", err)
+		}
+		slog.Info("exported file", "path", target, "bytes", len(raw))
+	}
+	return nil
+}
+
+// assetCollector is a blackfriday renderer that records the destinations of the links and images in a document.
+type assetCollector struct {
+	blackfriday.Renderer
+	destinations []string
+}
+
+func (c *assetCollector) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	c.destinations = append(c.destinations, string(link))
+	c.Renderer.Link(out, link, title, content)
+}
+
+func (c *assetCollector) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	c.destinations = append(c.destinations, string(link))
+	c.Renderer.Image(out, link, title, alt)
+}
+
+// localAssets returns the slash separated paths, relative to the served directory, of the local files that the page
+// links to or embeds. Links to other markdown documents and links without a file extension, which are usually routes,
+// are not assets.
+func localAssets(p *page) ([]string, error) {
+	// the whole file is the body when the front matter is invalid, just like when it is rendered
+	_, body, _ := splitFrontMatter(p.Raw)
+	collector := &assetCollector{Renderer: blackfriday.HtmlRenderer(0, "", "")}
+	blackfriday.Markdown(body, collector, markdownExtensions)
+
+	var assets []string
+	for _, link := range collector.destinations {
+		if link == "" || strings.HasPrefix(link, "#") || isAbsoluteUrl(link) {
+			continue
+		}
+		if i := strings.IndexAny(link, "?#"); i >= 0 {
+			link = link[:i]
+		}
+		if unescaped, err := url.PathUnescape(link); err == nil {
+			link = unescaped
+		}
+		if ext := path.Ext(link); ext == "" || ext == ".md" {
+			continue
+		}
+		asset := path.Join(path.Dir(p.Source), link)
+		if asset == ".." || strings.HasPrefix(asset, "../") {
+			return nil, fmt.Errorf("the linked file '%s' is outside of the served directory", link)
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
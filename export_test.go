@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport_directory(t *testing.T) {
+	dir, out := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "src", "foo"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "index.md"), []byte("# index\n\n[bar](foo/bar.md)\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "foo", "bar.md"), []byte("# bar\n\n![pic](pic.png) [home](../index.md)\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "foo", "pic.png"), []byte("png"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "src", "foo", "baz.md"), []byte("no heading\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "some.css"), []byte("body {}"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "icon.png"), []byte("icon"), 0600))
+
+	output := new(bytes.Buffer)
+	require.NoError(t, mainInner([]string{"md-http", "export", "-out", out, "-css", filepath.Join(dir, "some.css"), "-favicon", filepath.Join(dir, "icon.png"), filepath.Join(dir, "src")}, output))
+	assert.Empty(t, output.String())
+
+	for name, expected := range map[string]string{
+		"default.css":         "body {}",
+		"default-favicon.png": "icon",
+		"foo/pic.png":         "png",
+	} {
+		raw, err := os.ReadFile(filepath.Join(out, name))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(raw), name)
+	}
+	raw, err := os.ReadFile(filepath.Join(out, "index.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `<a href="./foo/bar.html"`)
+	assert.Contains(t, string(raw), `<link rel="stylesheet" type="text/css" href="default.css">`)
+	raw, err = os.ReadFile(filepath.Join(out, "foo", "bar.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `<img src="pic.png" alt="pic" /> <a href="../">home</a>`)
+	assert.Contains(t, string(raw), `<link rel="stylesheet" type="text/css" href="../default.css">`)
+	assert.Contains(t, string(raw), `<a href="../foo/baz.html">baz</a>`)
+}
+
+func TestExport_stdout(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+
+	output := new(bytes.Buffer)
+	require.NoError(t, mainInner([]string{"md-http", "export", "-title", "Exported", mdPath}, output))
+	assert.Contains(t, output.String(), "<title>Exported</title>")
+	assert.Contains(t, output.String(), ">example</h1>")
+}
+
+func TestExport_missingFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("![pic](missing.png)\n"), 0600))
+
+	err := mainInner([]string{"md-http", "export", "-out", t.TempDir(), filepath.Join(dir, "missing.md")}, new(bytes.Buffer))
+	assert.ErrorContains(t, err, "failed to open the file")
+	err = mainInner([]string{"md-http", "export", "-out", t.TempDir(), "-css", filepath.Join(dir, "missing.css"), filepath.Join(dir, "index.md")}, new(bytes.Buffer))
+	assert.ErrorContains(t, err, "failed to read the css file")
+	err = mainInner([]string{"md-http", "export", "-out", t.TempDir(), filepath.Join(dir, "index.md")}, new(bytes.Buffer))
+	assert.ErrorContains(t, err, "failed to read a file linked from")
+}
+
+func TestExport_stdoutMultiplePages(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("# index\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.md"), []byte("# other\n"), 0600))
+
+	err := mainInner([]string{"md-http", "export", dir}, new(bytes.Buffer))
+	assert.ErrorContains(t, err, "can only write a single page to stdout but found 2")
+}
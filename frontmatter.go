@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatter is the optional metadata block at the start of a markdown document. A YAML block is fenced by '---'
+// lines and a TOML block is fenced by '+++' lines.
+type frontMatter struct {
+	Title       string
+	Description string
+	Css         string
+	Favicon     string
+	Lang        string
+	// Meta holds every key in the block, including the ones above, so that templates can use arbitrary variables.
+	Meta map[string]any
+}
+
+// splitFrontMatter removes the front matter from the start of the raw markdown and parses it. Documents without front
+// matter are returned unchanged with empty metadata. When the block has no closing fence or doesn't parse, the document
+// is also returned unchanged with empty metadata, along with the error, since a leading '---' may just be a horizontal
+// rule.
+func splitFrontMatter(raw []byte) (frontMatter, []byte, error) {
+	var fm frontMatter
+	firstLine, rest, _ := cutLine(raw)
+	var unmarshal func([]byte, any) error
+	var closing [][]byte
+	switch string(firstLine) {
+	case "---":
+		unmarshal, closing = yaml.Unmarshal, [][]byte{[]byte("---"), []byte("...")}
+	case "+++":
+		unmarshal, closing = toml.Unmarshal, [][]byte{[]byte("+++")}
+	default:
+		return fm, raw, nil
+	}
+
+	// find the closing fence
+	for offset := 0; offset < len(rest); {
+		line, remainder, _ := cutLine(rest[offset:])
+		for _, c := range closing {
+			if bytes.Equal(line, c) {
+				if err := unmarshal(rest[:offset], &fm.Meta); err != nil {
+					return frontMatter{}, raw, fmt.Errorf("failed to parse front matter: %w", err)
+				}
+				fm.Title = metaString(fm.Meta, "title")
+				fm.Description = metaString(fm.Meta, "description")
+				fm.Css = metaString(fm.Meta, "css")
+				fm.Favicon = metaString(fm.Meta, "favicon")
+				fm.Lang = metaString(fm.Meta, "lang")
+				return fm, remainder, nil
+			}
+		}
+		offset = len(rest) - len(remainder)
+	}
+	return fm, raw, fmt.Errorf("failed to parse front matter: missing closing '%s'", closing[0])
+}
+
+// cutLine returns the first line without its line ending, the remainder after it, and whether a line ending was found.
+func cutLine(raw []byte) ([]byte, []byte, bool) {
+	line, rest, found := bytes.Cut(raw, []byte("\n"))
+	return bytes.TrimSuffix(line, []byte("\r")), rest, found
+}
+
+// metaString returns the value of the key if it is a string, or an empty string.
+func metaString(meta map[string]any, key string) string {
+	if v, ok := meta[key].(string); ok {
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitFrontMatter_none(t *testing.T) {
+	for _, raw := range []string{"", "# heading\n", "text\n---\nmore\n", " ---\nx: y\n---\n"} {
+		fm, body, err := splitFrontMatter([]byte(raw))
+		assert.NoError(t, err)
+		assert.Equal(t, frontMatter{}, fm)
+		assert.Equal(t, raw, string(body))
+	}
+}
+
+func TestSplitFrontMatter_yaml(t *testing.T) {
+	fm, body, err := splitFrontMatter([]byte("---\r\ntitle: My Page\r\ndescription: All the links\r\nlang: en\r\nteam:\r\n  name: ops\r\n---\r\n# heading\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "My Page", fm.Title)
+	assert.Equal(t, "All the links", fm.Description)
+	assert.Equal(t, "en", fm.Lang)
+	assert.Equal(t, map[string]any{"name": "ops"}, fm.Meta["team"])
+	assert.Equal(t, "# heading\r\n", string(body))
+
+	fm, body, err = splitFrontMatter([]byte("---\ncss: https://example.com/a.css\n...\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/a.css", fm.Css)
+	assert.Equal(t, "", string(body))
+}
+
+func TestSplitFrontMatter_toml(t *testing.T) {
+	fm, body, err := splitFrontMatter([]byte("+++\ntitle = \"My Page\"\nfavicon = \"icon.png\"\ncount = 3\n+++\nbody\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "My Page", fm.Title)
+	assert.Equal(t, "icon.png", fm.Favicon)
+	assert.Equal(t, int64(3), fm.Meta["count"])
+	assert.Equal(t, "body\n", string(body))
+}
+
+func TestSplitFrontMatter_errors(t *testing.T) {
+	fm, body, err := splitFrontMatter([]byte("---\ntitle: x\n"))
+	assert.EqualError(t, err, "failed to parse front matter: missing closing '---'")
+	assert.Equal(t, frontMatter{}, fm)
+	assert.Equal(t, "---\ntitle: x\n", string(body))
+	_, _, err = splitFrontMatter([]byte("+++\ntitle = \n+++\n"))
+	assert.ErrorContains(t, err, "failed to parse front matter")
+	fm, body, err = splitFrontMatter([]byte("---\n: [\n---\n"))
+	assert.ErrorContains(t, err, "failed to parse front matter")
+	assert.Equal(t, frontMatter{}, fm)
+	assert.Equal(t, "---\n: [\n---\n", string(body))
+}
+
+func TestContentStore_frontMatter(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("---\ntitle: Overridden\nlang: fr\ndescription: Some page\ncss: custom.css\nowner: ops\n---\n# Heading\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.md"), []byte("# Other\n"), 0600))
+	templatePath := filepath.Join(t.TempDir(), "page.html")
+	require.NoError(t, os.WriteFile(templatePath, []byte(`{{ .Title }}|{{ .Lang }}|{{ .Description }}|{{ .CssUrl }}|{{ .Meta.owner }}|{{ range .Nav.Children }}{{ .Label }}{{ end }}|{{ .Body }}`), 0600))
+
+	store := &contentStore{MarkdownPath: dir, TemplatePath: templatePath, PageTitle: "Site", CssUrl: "default.css"}
+	require.NoError(t, store.Load())
+	assert.Equal(t, "Overridden|fr|Some page|custom.css|ops|Other|<h1 id=\"heading\">Heading</h1>\n", string(store.Snapshot().Pages["/"].Html))
+	assert.Equal(t, "Other - Site|||default.css||Other|<h1 id=\"other\">Other</h1>\n", string(store.Snapshot().Pages["/other"].Html))
+}
+
+func TestContentStore_leadingHorizontalRule(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("---\n\nSome text\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.md"), []byte("---\nSome text\n---\n"), 0600))
+	templatePath := filepath.Join(t.TempDir(), "page.html")
+	require.NoError(t, os.WriteFile(templatePath, []byte(`{{ .Meta }}|{{ .Body }}`), 0600))
+
+	// pages that start with a horizontal rule rather than front matter are rendered as they are
+	store := &contentStore{MarkdownPath: dir, TemplatePath: templatePath}
+	require.NoError(t, store.Load())
+	assert.Equal(t, "map[]|<hr />\n\n<p>Some text</p>\n", string(store.Snapshot().Pages["/"].Html))
+	assert.Equal(t, "map[]|<hr />\n\n<h2 id=\"some-text\">Some text</h2>\n", string(store.Snapshot().Pages["/other"].Html))
+}
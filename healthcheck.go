@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultHealthcheckTimeout     = time.Second * 5
+	DefaultHealthcheckUsagePrefix = `Usage: md-http healthcheck [options...]
+
+Checks the /healthz route of a running md-http server and exits with 0 if it is healthy or 1 if it is not. This is
+intended for container health checks where no http client is available.
+`
+)
+
+// mainHealthcheck is the entrypoint of the healthcheck subcommand.
+func mainHealthcheck(args []string, output io.Writer) error {
+	fs := flag.NewFlagSet(filepath.Base(args[0]), flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	var listenAddr, tlsCert, tlsClientCert, tlsClientKey string
+	var timeout time.Duration
+	fs.StringVar(&listenAddr, "listen", DefaultListenAddr, "The socket address the server is listening on")
+	fs.StringVar(&tlsCert, "tls-cert", DefaultTlsCert, "The certificate file of the server, when set the check uses https")
+	fs.StringVar(&tlsClientCert, "tls-client-cert", "", "An optional client certificate file to present to a server that requires one")
+	fs.StringVar(&tlsClientKey, "tls-client-key", "", "The private key file of the -tls-client-cert certificate")
+	fs.DurationVar(&timeout, "timeout", DefaultHealthcheckTimeout, "How long to wait for the response")
+
+	fs.Usage = func() {
+		_, _ = fs.Output().Write([]byte(DefaultHealthcheckUsagePrefix))
+		fs.PrintDefaults()
+		_, _ = fs.Output().Write([]byte(DefaultUsageSuffix))
+	}
+	if err := setFlagsFromEnv(fs); err != nil {
+		return err
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return http.ErrServerClosed
+		}
+		return err
+	}
+	if fs.NArg() != 0 {
+		_, _ = fs.Output().Write([]byte("Expected no arguments!\n\n"))
+		fs.Usage()
+		return http.ErrServerClosed
+	}
+	addrPort, err := netip.ParseAddrPort(listenAddr)
+	if err != nil {
+		_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'listen' '%s'\n\n", listenAddr)
+		fs.Usage()
+		return http.ErrServerClosed
+	}
+
+	// the certificate of the server is not verified, as the check connects to an address rather than the name the
+	// certificate was issued for
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if tlsClientCert != "" || tlsClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(tlsClientCert, tlsClientKey)
+		if err != nil {
+			return fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if err := healthcheck(healthcheckUrl(addrPort, tlsCert != ""), timeout, tlsConfig); err != nil {
+		return fmt.Errorf("unhealthy: %w", err)
+	}
+	_, _ = fmt.Fprintln(output, "healthy")
+	return nil
+}
+
+// healthcheckUrl returns the url of the health check on the given listen address. A server listening on all
+// interfaces is reached through the loopback interface.
+func healthcheckUrl(addrPort netip.AddrPort, https bool) string {
+	addr := addrPort.Addr()
+	if addr.IsUnspecified() {
+		if addr.Is4() {
+			addr = netip.AddrFrom4([4]byte{127, 0, 0, 1})
+		} else {
+			addr = netip.IPv6Loopback()
+		}
+	}
+	scheme := "http://"
+	if https {
+		scheme = "https://"
+	}
+	return scheme + netip.AddrPortFrom(addr, addrPort.Port()).String() + "/healthz"
+}
+
+// healthcheck returns an error unless a GET of the url succeeds within the timeout.
+func healthcheck(url string, timeout time.Duration, tlsConfig *tls.Config) error {
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
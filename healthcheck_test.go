@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthcheckUrl(t *testing.T) {
+	for listen, expected := range map[string]string{
+		"0.0.0.0:8080":     "http://127.0.0.1:8080/healthz",
+		"[::]:8080":        "http://[::1]:8080/healthz",
+		"10.0.0.1:80":      "http://10.0.0.1:80/healthz",
+		"[2001:db8::1]:80": "http://[2001:db8::1]:80/healthz",
+	} {
+		assert.Equal(t, expected, healthcheckUrl(netip.MustParseAddrPort(listen), false), listen)
+	}
+	assert.Equal(t, "https://127.0.0.1:8443/healthz", healthcheckUrl(netip.MustParseAddrPort("0.0.0.0:8443"), true))
+}
+
+func TestMainHealthcheck(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath}, nil)
+	listen := strings.TrimPrefix(baseUrl, "http://")
+
+	output := new(bytes.Buffer)
+	require.NoError(t, mainInner([]string{"md-http", "healthcheck", "-listen", listen}, output))
+	assert.Equal(t, "healthy\n", output.String())
+
+	t.Setenv("MDHTTP_listen", listen)
+	require.NoError(t, mainInner([]string{"md-http", "healthcheck"}, new(bytes.Buffer)))
+
+	port, err := freePort()
+	require.NoError(t, err)
+	err = mainInner([]string{"md-http", "healthcheck", "-listen", fmt.Sprintf("127.0.0.1:%d", port)}, new(bytes.Buffer))
+	assert.ErrorContains(t, err, "unhealthy: ")
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// HistoryPath is the route of the revision history of the edited pages.
+const HistoryPath = "/_history"
+
+// revision is a version of a markdown document accepted by an edit.
+type revision struct {
+	Route     string    `json:"route"`
+	Hash      string    `json:"hash"`
+	Author    string    `json:"author"`
+	Timestamp time.Time `json:"timestamp"`
+	Content   string    `json:"content"`
+}
+
+// historyStore keeps every revision of the edited documents as a timestamped json file in a directory. The file names
+// sort in the order the revisions were made.
+type historyStore struct {
+	Dir string
+}
+
+// contentHash identifies the revision of a markdown document.
+func contentHash(raw []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(raw))
+}
+
+// Record stores a new revision of the document on the given route.
+func (h *historyStore) Record(route string, raw []byte, author string) (revision, error) {
+	rev := revision{Route: route, Hash: contentHash(raw), Author: author, Timestamp: time.Now().UTC(), Content: string(raw)}
+	encoded, err := json.Marshal(rev)
+	if err != nil {
+		return rev, fmt.Errorf("failed to encode revision: %w", err)
+	}
+	name := rev.Timestamp.Format("20060102T150405.000000000Z") + "-" + rev.Hash[:12] + ".json"
+	if err := writeFileAtomic(filepath.Join(h.Dir, name), encoded); err != nil {
+		return rev, fmt.Errorf("failed to write revision: %w", err)
+	}
+	slog.Info("recorded revision", "route", route, "hash", rev.Hash, "author", author)
+	return rev, nil
+}
+
+// Ensure records the document as a revision unless it is already the latest one. This keeps the version of the file
+// that existed before the first edit, or that was changed outside md-http, so that it can be restored.
+func (h *historyStore) Ensure(route string, raw []byte) error {
+	revisions, err := h.List(route)
+	if err != nil {
+		return err
+	}
+	if len(revisions) > 0 && revisions[0].Hash == contentHash(raw) {
+		return nil
+	}
+	_, err = h.Record(route, raw, "")
+	return err
+}
+
+// List returns the revisions of the document on the given route, newest first.
+func (h *historyStore) List(route string) ([]revision, error) {
+	entries, err := os.ReadDir(h.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revisions: %w", err)
+	}
+	var revisions []revision
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || filepath.Ext(entry.Name()) != ".json" || !entry.Type().IsRegular() {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(h.Dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read revision: %w", err)
+		}
+		var rev revision
+		if err := json.Unmarshal(raw, &rev); err != nil || len(rev.Hash) != sha256.Size*2 {
+			slog.Warn("skipping invalid revision file", "path", filepath.Join(h.Dir, entry.Name()), "err", err)
+			continue
+		}
+		if rev.Route == route {
+			revisions = append(revisions, rev)
+		}
+	}
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].Timestamp.After(revisions[j].Timestamp)
+	})
+	return revisions, nil
+}
+
+// Get returns the latest revision of the document on the given route with the given hash.
+func (h *historyStore) Get(route, hash string) (revision, bool, error) {
+	revisions, err := h.List(route)
+	if err != nil {
+		return revision{}, false, err
+	}
+	for _, rev := range revisions {
+		if rev.Hash == hash {
+			return rev, true, nil
+		}
+	}
+	return revision{}, false, nil
+}
+
+// requestAuthor returns the name recorded as the author of an edit: the authenticated client or basic auth user if
+// there is one, otherwise the address of the client.
+func requestAuthor(request *http.Request) string {
+	if id := requestIdentity(request); id != nil {
+		return id.Name
+	}
+	if user, _, ok := request.BasicAuth(); ok && user != "" {
+		return user
+	}
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		return host
+	}
+	return request.RemoteAddr
+}
+
+// historyEntry is a revision as shown in the history pages, with links relative to the page being rendered.
+type historyEntry struct {
+	revision
+	Short       string
+	Current     bool
+	Url         string
+	DiffUrl     string
+	RollbackUrl string
+}
+
+// diffLine is a line of a unified diff, with a class for highlighting.
+type diffLine struct {
+	Class string
+	Text  string
+}
+
+// historyData is the input to the history templates.
+type historyData struct {
+	Route      string
+	PageUrl    string
+	HistoryUrl string
+	CssUrl     string
+	Editable   bool
+	Etag       string
+	Revisions  []historyEntry
+	Revision   historyEntry
+	Body       template.HTML
+	From, To   string
+	Diff       []diffLine
+}
+
+var historyTemplate = template.Must(template.New("history").Parse(`
+{{- define "head" }}<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>History of {{ .Route }}</title>
+  {{- if .CssUrl }}
+  <link rel="stylesheet" type="text/css" href="{{ .CssUrl }}">
+  {{- end }}
+  <style>
+    .md-http-history form { display: inline; }
+    .md-http-diff .add { background: #e6ffec; }
+    .md-http-diff .del { background: #ffebe9; }
+    .md-http-diff .hunk { color: #6e7781; }
+  </style>
+</head>
+<body>
+{{- end }}
+{{- define "list" }}{{ template "head" . }}
+<h1>History of <a href="{{ .PageUrl }}">{{ .Route }}</a></h1>
+<table class="md-http-history">
+  <thead><tr><th>Revision</th><th>Author</th><th>Time</th><th></th></tr></thead>
+  <tbody>
+  {{- range .Revisions }}
+    <tr>
+      <td><a href="{{ .Url }}"><code>{{ .Short }}</code></a>{{ if .Current }} (current){{ end }}</td>
+      <td>{{ or .Author "unknown" }}</td>
+      <td>{{ .Timestamp.Format "2006-01-02 15:04:05 MST" }}</td>
+      <td>
+        {{- if not .Current }}<a href="{{ .DiffUrl }}">diff with current</a>{{ end }}
+        {{- if and $.Editable (not .Current) }}
+        <form method="post" action="{{ .RollbackUrl }}">
+          <input type="hidden" name="etag" value="{{ $.Etag }}">
+          <button type="submit">Roll back</button>
+        </form>
+        {{- end }}
+      </td>
+    </tr>
+  {{- else }}
+    <tr><td colspan="4">No revisions have been recorded yet.</td></tr>
+  {{- end }}
+  </tbody>
+</table>
+</body>
+</html>
+{{ end }}
+{{- define "revision" }}{{ template "head" . }}
+<p class="md-http-revision">
+  Revision <code>{{ .Revision.Short }}</code> of <a href="{{ .PageUrl }}">{{ .Route }}</a>
+  by {{ or .Revision.Author "unknown" }} at {{ .Revision.Timestamp.Format "2006-01-02 15:04:05 MST" }}.
+  <a href="{{ .HistoryUrl }}">Back to the history</a>.
+</p>
+{{ .Body }}
+</body>
+</html>
+{{ end }}
+{{- define "diff" }}{{ template "head" . }}
+<h1>Changes to <a href="{{ .PageUrl }}">{{ .Route }}</a></h1>
+<p>From <code>{{ .From }}</code> to <code>{{ .To }}</code>. <a href="{{ .HistoryUrl }}">Back to the history</a>.</p>
+<pre class="md-http-diff">
+{{- range .Diff }}
+<span class="{{ .Class }}">{{ .Text }}</span>
+{{- else }}
+The revisions are identical.
+{{- end }}
+</pre>
+</body>
+</html>
+{{ end }}
+`))
+
+// historyHandler serves the revision history of the page given by the 'page' query parameter. '/_history' lists the
+// revisions, '/_history/<hash>' renders an old revision, '/_history/diff?from=<hash>&to=<hash>' shows the changes
+// between two revisions, where a missing hash is the current version, and a POST to '/_history/<hash>/rollback'
+// restores a revision. A rollback is an edit, so it needs the current etag of the page in the If-Match header or the
+// 'etag' form field.
+func historyHandler(content *contentStore, editable bool) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		route := request.URL.Query().Get("page")
+		if route == "" {
+			route = "/"
+		}
+		current, ok := content.Snapshot().Pages[route]
+		if !ok {
+			http.NotFound(writer, request)
+			return
+		}
+		hash, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(request.URL.Path, HistoryPath), "/"), "/")
+		if action == "rollback" {
+			if request.Method != "POST" || !editable {
+				writer.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			rollback(content, current, hash, writer, request)
+			return
+		} else if action != "" {
+			http.NotFound(writer, request)
+			return
+		} else if request.Method != "GET" {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		links := func(to string) string {
+			return relativeLink(request.URL.Path, to) + "?page=" + url.QueryEscape(route)
+		}
+		currentHash := contentHash(current.Raw)
+		data := historyData{
+			Route:      route,
+			PageUrl:    relativeLink(request.URL.Path, route),
+			HistoryUrl: links(HistoryPath),
+			CssUrl:     siteRelativeUrl(request.URL.Path, content.CssUrl),
+			Editable:   editable,
+			Etag:       current.Hash,
+		}
+		entry := func(rev revision) historyEntry {
+			return historyEntry{
+				revision:    rev,
+				Short:       rev.Hash[:12],
+				Current:     rev.Hash == currentHash,
+				Url:         links(HistoryPath + "/" + rev.Hash),
+				DiffUrl:     links(HistoryPath+"/diff") + "&from=" + rev.Hash,
+				RollbackUrl: links(HistoryPath + "/" + rev.Hash + "/rollback"),
+			}
+		}
+
+		var name string
+		switch hash {
+		case "":
+			revisions, err := content.History.List(route)
+			if err != nil {
+				slog.Error("failed to list revisions", "err", err)
+				http.Error(writer, "failed to list revisions", http.StatusInternalServerError)
+				return
+			}
+			for _, rev := range revisions {
+				data.Revisions = append(data.Revisions, entry(rev))
+			}
+			name = "list"
+		case "diff":
+			sides := [2]string{string(current.Raw), string(current.Raw)}
+			names := [2]string{"current", "current"}
+			for i, param := range []string{"from", "to"} {
+				if h := request.URL.Query().Get(param); h != "" {
+					rev, ok, err := content.History.Get(route, h)
+					if err != nil {
+						slog.Error("failed to read revision", "err", err)
+						http.Error(writer, "failed to read revision", http.StatusInternalServerError)
+						return
+					} else if !ok {
+						http.NotFound(writer, request)
+						return
+					}
+					sides[i], names[i] = rev.Content, rev.Hash[:12]
+				}
+			}
+			data.From, data.To = names[0], names[1]
+			data.Diff = unifiedDiff(sides[0], sides[1], data.From, data.To)
+			name = "diff"
+		default:
+			rev, ok, err := content.History.Get(route, hash)
+			if err != nil {
+				slog.Error("failed to read revision", "err", err)
+				http.Error(writer, "failed to read revision", http.StatusInternalServerError)
+				return
+			} else if !ok {
+				http.NotFound(writer, request)
+				return
+			}
+			_, body, err := splitFrontMatter([]byte(rev.Content))
+			if err != nil {
+				body = []byte(rev.Content)
+			}
+			routeBySource := make(map[string]string)
+			for r, p := range content.Snapshot().Pages {
+				routeBySource[p.Source] = r
+			}
+			// links are rewritten relative to the history page rather than the route of the document
+			rendered, _, err := renderMarkdown(body, func(link string) string {
+				return rewriteMarkdownLink(link, current.Source, request.URL.Path, routeBySource)
+			})
+			if err != nil {
+				slog.Error("failed to render revision", "err", err)
+				http.Error(writer, "failed to render revision", http.StatusInternalServerError)
+				return
+			}
+			data.Revision, data.Body = entry(rev), template.HTML(rendered)
+			name = "revision"
+		}
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writer.Header().Set("Cache-Control", "no-store")
+		if err := historyTemplate.ExecuteTemplate(writer, name, data); err != nil {
+			slog.Error("failed to render the history", "err", err)
+		}
+	}
+}
+
+// rollback restores the revision with the given hash as the current version of the page.
+func rollback(content *contentStore, current *page, hash string, writer http.ResponseWriter, request *http.Request) {
+	if crossSite(request) {
+		http.Error(writer, "cross-site requests are not allowed", http.StatusForbidden)
+		return
+	}
+	request.Body = http.MaxBytesReader(writer, request.Body, DefaultMaxEditBytes)
+	if err := request.ParseForm(); err != nil {
+		writeUpdateError(writer, err)
+		return
+	}
+	ifMatch := request.Header.Get("If-Match")
+	if ifMatch == "" {
+		ifMatch = request.PostForm.Get("etag")
+	}
+	if ifMatch == "" {
+		http.Error(writer, "the If-Match header is required", http.StatusPreconditionRequired)
+		return
+	}
+	rev, ok, err := content.History.Get(current.Route, hash)
+	if err != nil {
+		writeUpdateError(writer, err)
+		return
+	} else if !ok {
+		http.NotFound(writer, request)
+		return
+	}
+	updated, err := content.Update(request.Context(), current.Route, ifMatch, []byte(rev.Content), requestAuthor(request))
+	if err != nil {
+		writeUpdateError(writer, err)
+		return
+	}
+	writer.Header().Set("Etag", updated.Hash)
+	http.Redirect(writer, request, relativeLink(request.URL.Path, HistoryPath)+"?page="+url.QueryEscape(updated.Route), http.StatusSeeOther)
+}
+
+// unifiedDiff compares two versions of a document line by line.
+func unifiedDiff(from, to, fromName, toName string) []diffLine {
+	text, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(from),
+		B:        splitLines(to),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+	var lines []diffLine
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		line = strings.TrimSuffix(line, "\n")
+		class := ""
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			class = "hunk"
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			class = "add"
+		case strings.HasPrefix(line, "-"):
+			class = "del"
+		}
+		lines = append(lines, diffLine{Class: class, Text: line})
+	}
+	return lines
+}
+
+// splitLines splits the text into lines that keep their line endings. Unlike difflib.SplitLines, a trailing line ending
+// does not produce an extra empty line.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
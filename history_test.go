@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryStore(t *testing.T) {
+	h := &historyStore{Dir: t.TempDir()}
+	require.NoError(t, h.Ensure("/", []byte("a")))
+	require.NoError(t, h.Ensure("/", []byte("a")))
+	_, err := h.Record("/", []byte("b"), "alice")
+	require.NoError(t, err)
+	_, err = h.Record("/other", []byte("c"), "bob")
+	require.NoError(t, err)
+
+	revisions, err := h.List("/")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, "b", revisions[0].Content)
+	assert.Equal(t, "alice", revisions[0].Author)
+	assert.Equal(t, contentHash([]byte("b")), revisions[0].Hash)
+	assert.Equal(t, "a", revisions[1].Content)
+	assert.Equal(t, "", revisions[1].Author)
+
+	rev, ok, err := h.Get("/", contentHash([]byte("a")))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", rev.Content)
+	_, ok, err = h.Get("/", contentHash([]byte("c")))
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	assert.Equal(t, []diffLine{
+		{Text: "--- a"},
+		{Text: "+++ b"},
+		{Class: "hunk", Text: "@@ -1,2 +1,2 @@"},
+		{Text: " one"},
+		{Class: "del", Text: "-two"},
+		{Class: "add", Text: "+three"},
+	}, unifiedDiff("one\ntwo\n", "one\nthree\n", "a", "b"))
+	assert.Empty(t, unifiedDiff("same\n", "same\n", "a", "b"))
+}
+
+func TestRunHistory(t *testing.T) {
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# first\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, Edit: true, HistoryDir: filepath.Join(dir, "history")}, nil)
+
+	resp, body := getBody(t, baseUrl+"/_history")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "No revisions have been recorded yet.")
+
+	resp, _ = getBody(t, baseUrl+"/")
+	req, _ := http.NewRequest(http.MethodPut, baseUrl+"/", strings.NewReader("# second\n"))
+	req.Header.Set("If-Match", resp.Header.Get("Etag"))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	etag := resp.Header.Get("Etag")
+
+	firstHash, secondHash := contentHash([]byte("# first\n")), contentHash([]byte("# second\n"))
+	_, body = getBody(t, baseUrl+"/_history")
+	assert.Contains(t, body, `<code>`+secondHash[:12]+`</code></a> (current)`)
+	assert.Contains(t, body, `<a href="./_history/`+firstHash+`?page=%2F"><code>`+firstHash[:12]+`</code></a>`)
+	assert.Contains(t, body, `<td>127.0.0.1</td>`)
+	assert.Contains(t, body, `<td>unknown</td>`)
+	assert.Contains(t, body, `<input type="hidden" name="etag" value="`+etag+`">`)
+
+	resp, body = getBody(t, baseUrl+"/_history/"+firstHash)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, ">first</h1>")
+	resp, _ = getBody(t, baseUrl+"/_history/"+contentHash([]byte("missing")))
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	_, body = getBody(t, baseUrl+"/_history/diff?from="+firstHash)
+	assert.Contains(t, body, `<span class="del">-# first</span>`)
+	assert.Contains(t, body, `<span class="add">&#43;# second</span>`)
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	rollbackUrl := baseUrl + "/_history/" + firstHash + "/rollback"
+	resp, err = client.PostForm(rollbackUrl, url.Values{"etag": {"stale"}})
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp, err = client.PostForm(rollbackUrl, nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
+
+	req, _ = http.NewRequest(http.MethodPost, rollbackUrl, nil)
+	req.Header.Set("If-Match", etag)
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req, _ = http.NewRequest(http.MethodPost, rollbackUrl, nil)
+	req.Header.Set("If-Match", etag)
+	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	raw, err := os.ReadFile(mdPath)
+	require.NoError(t, err)
+	assert.Equal(t, "# first\n", string(raw))
+
+	revisions, err := (&historyStore{Dir: filepath.Join(dir, "history")}).List("/")
+	require.NoError(t, err)
+	assert.Len(t, revisions, 3)
+}
+
+func TestRunHistory_readOnly(t *testing.T) {
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# first\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, HistoryDir: filepath.Join(dir, "history")}, nil)
+
+	resp, err := http.Post(baseUrl+"/_history/"+contentHash([]byte("# first\n"))+"/rollback", "", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
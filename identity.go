@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// identity is the authenticated client of a request.
+type identity struct {
+	// Name identifies the client, such as the user name or the subject of its certificate.
+	Name   string
+	Email  string
+	Groups []string
+	// Method is how the client was authenticated, such as 'mtls' or 'proxy'.
+	Method string
+}
+
+type identityKey struct{}
+
+// withIdentity returns the request with the identity attached to its context.
+func withIdentity(request *http.Request, id *identity) *http.Request {
+	return request.WithContext(context.WithValue(request.Context(), identityKey{}, id))
+}
+
+// requestIdentity returns the identity attached to the request, or nil when the client is anonymous.
+func requestIdentity(request *http.Request) *identity {
+	id, _ := request.Context().Value(identityKey{}).(*identity)
+	return id
+}
+
+// proxyIdentity returns the identity that an authenticating proxy, such as oauth2-proxy, put in the X-Forwarded-User,
+// X-Forwarded-Email, and X-Forwarded-Groups headers. The headers are only trusted when the peer is one of the proxies,
+// and nil is returned when it isn't or no user is given.
+func proxyIdentity(request *http.Request, proxies []netip.Prefix) *identity {
+	user := strings.TrimSpace(request.Header.Get("X-Forwarded-User"))
+	if user == "" || !containsAddr(proxies, remoteAddr(request)) {
+		return nil
+	}
+	return &identity{
+		Name:   user,
+		Email:  strings.TrimSpace(request.Header.Get("X-Forwarded-Email")),
+		Groups: splitList(request.Header.Get("X-Forwarded-Groups")),
+		Method: "proxy",
+	}
+}
+
+// identityAllowed returns whether the identity is named by the users allow-list, by name or email compared
+// case-insensitively, or has one of the groups in the groups allow-list. Without allow-lists every identity is allowed.
+func identityAllowed(id *identity, users, groups []string) bool {
+	if len(users) == 0 && len(groups) == 0 {
+		return true
+	}
+	for _, u := range users {
+		if strings.EqualFold(u, id.Name) || (id.Email != "" && strings.EqualFold(u, id.Email)) {
+			return true
+		}
+	}
+	for _, g := range id.Groups {
+		if slices.Contains(groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateIdentity returns the identity of a verified client certificate, which is named by its subject common name
+// or, when it has none, its first subject alternative name.
+func certificateIdentity(cert *x509.Certificate) *identity {
+	id := &identity{Name: cert.Subject.CommonName, Method: "mtls"}
+	if len(cert.EmailAddresses) > 0 {
+		id.Email = cert.EmailAddresses[0]
+	}
+	if names := certificateNames(cert); id.Name == "" && len(names) > 0 {
+		id.Name = names[0]
+	}
+	return id
+}
+
+// certificateNames returns the subject alternative names of the certificate.
+func certificateNames(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// certificateAllowed returns whether the subject common name or any of the subject alternative names of the
+// certificate are in the allow-list. Names are compared case-insensitively.
+func certificateAllowed(cert *x509.Certificate, allowed []string) bool {
+	for _, name := range append([]string{cert.Subject.CommonName}, certificateNames(cert)...) {
+		for _, a := range allowed {
+			if name != "" && strings.EqualFold(name, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
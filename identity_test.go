@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ops")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "ops-bot"},
+		DNSNames:       []string{"bot.example.org"},
+		EmailAddresses: []string{"ops@example.org"},
+		URIs:           []*url.URL{spiffe},
+	}
+	assert.Equal(t, &identity{Name: "ops-bot", Email: "ops@example.org", Method: "mtls"}, certificateIdentity(cert))
+	assert.True(t, certificateAllowed(cert, []string{"OPS-BOT"}))
+	assert.True(t, certificateAllowed(cert, []string{"someone", "bot.example.org"}))
+	assert.True(t, certificateAllowed(cert, []string{"spiffe://example.org/ops"}))
+	assert.False(t, certificateAllowed(cert, []string{"example.org"}))
+
+	cert.Subject.CommonName = ""
+	assert.Equal(t, "bot.example.org", certificateIdentity(cert).Name)
+	assert.False(t, certificateAllowed(cert, []string{""}))
+}
+
+func TestProxyIdentity(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	request := &http.Request{RemoteAddr: "10.1.2.3:5000", Header: http.Header{}}
+	assert.Nil(t, proxyIdentity(request, proxies))
+
+	request.Header.Set("X-Forwarded-User", "alice")
+	request.Header.Set("X-Forwarded-Email", "alice@example.com")
+	request.Header.Set("X-Forwarded-Groups", "docs, eng")
+	assert.Equal(t, &identity{Name: "alice", Email: "alice@example.com", Groups: []string{"docs", "eng"}, Method: "proxy"}, proxyIdentity(request, proxies))
+
+	// only the proxies are trusted
+	request.RemoteAddr = "192.0.2.1:5000"
+	assert.Nil(t, proxyIdentity(request, proxies))
+}
+
+func TestIdentityAllowed(t *testing.T) {
+	alice := &identity{Name: "alice", Email: "alice@example.com", Groups: []string{"eng"}}
+	assert.True(t, identityAllowed(alice, nil, nil))
+	assert.True(t, identityAllowed(alice, []string{"Alice"}, nil))
+	assert.True(t, identityAllowed(alice, []string{"alice@EXAMPLE.com"}, nil))
+	assert.True(t, identityAllowed(alice, []string{"bob"}, []string{"eng"}))
+	assert.False(t, identityAllowed(alice, []string{"bob"}, []string{"Eng"}))
+	assert.False(t, identityAllowed(&identity{Name: "bob"}, []string{""}, nil))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// prefixesValue is a flag.Value of CIDR prefixes. The flag may be repeated and each value may be a comma separated
+// list, see parsePrefixes.
+type prefixesValue []netip.Prefix
+
+func (p *prefixesValue) String() string {
+	if p == nil {
+		return ""
+	}
+	items := make([]string, len(*p))
+	for i, prefix := range *p {
+		items[i] = prefix.String()
+	}
+	return strings.Join(items, ",")
+}
+
+func (p *prefixesValue) Set(value string) error {
+	prefixes, err := parsePrefixes(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, prefixes...)
+	return nil
+}
+
+const (
+	// ipDenied and ipNotAllowed are the reasons a request is refused by the ipFilter.
+	ipDenied     = "denied"
+	ipNotAllowed = "not_allowed"
+)
+
+// ipFilter refuses requests by the address of the client. The deny-list takes precedence over the allow-list, and when
+// there is an allow-list only the clients in it are permitted. Clients without an ip address, such as on a unix socket,
+// are in neither list.
+type ipFilter struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+	// TrustedProxies are the proxies whose forwarded headers are trusted for the client address, see clientAddr.
+	TrustedProxies []netip.Prefix
+}
+
+// Check returns the client address and, when the request is refused, the reason.
+func (f *ipFilter) Check(request *http.Request) (netip.Addr, string) {
+	addr := clientAddr(request, f.TrustedProxies)
+	if addr.IsValid() && containsAddr(f.Deny, addr) {
+		return addr, ipDenied
+	}
+	if len(f.Allow) > 0 && (!addr.IsValid() || !containsAddr(f.Allow, addr)) {
+		return addr, ipNotAllowed
+	}
+	return addr, ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixesValue(t *testing.T) {
+	var prefixes []netip.Prefix
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Var((*prefixesValue)(&prefixes), "cidr", "")
+	require.NoError(t, fs.Parse([]string{"-cidr", "10.0.0.0/8, 192.0.2.1", "-cidr", "2001:db8::/32"}))
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, prefixes)
+	assert.Equal(t, "10.0.0.0/8,192.0.2.1/32,2001:db8::/32", (*prefixesValue)(&prefixes).String())
+	assert.Error(t, fs.Parse([]string{"-cidr", "nope"}))
+}
+
+func TestIpFilter_Check(t *testing.T) {
+	f := &ipFilter{
+		Allow:          []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")},
+		Deny:           []netip.Prefix{netip.MustParsePrefix("10.6.6.0/24")},
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("192.0.2.1/32")},
+	}
+	for remote, expected := range map[string]string{
+		"10.1.2.3:1000":        "",
+		"[2001:db8::1]:1000":   "",
+		"[::ffff:10.1.2.3]:80": "",
+		"10.6.6.6:1000":        ipDenied,
+		"198.51.100.1:1000":    ipNotAllowed,
+		"@":                    ipNotAllowed,
+	} {
+		_, reason := f.Check(&http.Request{RemoteAddr: remote, Header: http.Header{}})
+		assert.Equal(t, expected, reason, remote)
+	}
+
+	// the client address is taken from the forwarded headers of trusted proxies
+	request := &http.Request{RemoteAddr: "192.0.2.1:1000", Header: http.Header{"X-Forwarded-For": {"10.6.6.6"}}}
+	addr, reason := f.Check(request)
+	assert.Equal(t, netip.MustParseAddr("10.6.6.6"), addr)
+	assert.Equal(t, ipDenied, reason)
+	request.Header.Set("X-Forwarded-For", "10.1.1.1")
+	_, reason = f.Check(request)
+	assert.Equal(t, "", reason)
+
+	// without an allow-list everything not denied is permitted
+	f.Allow = nil
+	_, reason = f.Check(&http.Request{RemoteAddr: "198.51.100.1:1000", Header: http.Header{}})
+	assert.Equal(t, "", reason)
+}
+
+func TestRunIpFilter(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	port, err := freePort()
+	require.NoError(t, err)
+	metricsAddrPort := netip.MustParseAddrPort(fmt.Sprintf("127.0.0.1:%d", port))
+	baseUrl := startRun(t, argsStruct{
+		MarkdownFile:    mdPath,
+		MetricsAddrPort: metricsAddrPort,
+		AllowCidrs:      []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")},
+		DenyCidrs:       []netip.Prefix{netip.MustParsePrefix("198.51.100.66/32")},
+		TrustedProxies:  []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+	}, nil)
+
+	get := func(path, forwardedFor string) int {
+		request, err := http.NewRequest("GET", baseUrl+path, nil)
+		require.NoError(t, err)
+		if forwardedFor != "" {
+			request.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusForbidden, get("/", ""))
+	assert.Equal(t, http.StatusOK, get("/", "198.51.100.7"))
+	assert.Equal(t, http.StatusForbidden, get("/", "198.51.100.66"))
+	resp, _ := getBody(t, baseUrl+"/healthz")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = getBody(t, baseUrl+"/readyz")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// but the detailed readiness, which names local paths and errors, is not a probe
+	assert.Equal(t, http.StatusOK, get("/readyz", "198.51.100.66"))
+	assert.Equal(t, http.StatusForbidden, get("/readyz?verbose", "198.51.100.66"))
+
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + metricsAddrPort.String() + "/metrics")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, time.Second*5, time.Millisecond*20)
+	_, body := getBody(t, "http://"+metricsAddrPort.String()+"/metrics")
+	assert.Contains(t, body, `md_http_ip_refused_requests_total{reason="denied"} 2`+"\n")
+	assert.Contains(t, body, `md_http_ip_refused_requests_total{reason="not_allowed"} 1`+"\n")
+	assert.Contains(t, body, `md_http_requests_total{route="/",method="GET",status="403"} 2`+"\n")
+	assert.Contains(t, body, `md_http_requests_total{route="/readyz",method="GET",status="403"} 1`+"\n")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// LiveReloadPath is the route of the server-sent events stream used by the live reload script.
+const LiveReloadPath = "/_live"
+
+// liveReloadKeepAlive is how often a comment is sent on an idle event stream so that proxies don't close it.
+const liveReloadKeepAlive = time.Second * 15
+
+// liveReloadScript subscribes to the event stream and reloads the page when the content changes, restoring the scroll
+// position after the reload.
+var liveReloadScript = template.Must(template.New("live").Parse(`<script>
+(function () {
+  var key = "md-http-scroll:" + location.pathname;
+  var position = sessionStorage.getItem(key);
+  if (position !== null) {
+    sessionStorage.removeItem(key);
+    window.addEventListener("load", function () { window.scrollTo(0, parseInt(position, 10)); });
+  }
+  new EventSource({{ . }}).addEventListener("reload", function () {
+    sessionStorage.setItem(key, String(window.scrollY));
+    location.reload();
+  });
+})();
+</script>
+`))
+
+// injectLiveReloadScript inserts the live reload script before the closing body tag of the page, or at the end if
+// there is none.
+func injectLiveReloadScript(htmlContent []byte, eventsUrl string) []byte {
+	script := new(bytes.Buffer)
+	if err := liveReloadScript.Execute(script, eventsUrl); err != nil {
+		// this can only fail if the template itself is broken
+		panic(err)
+	}
+	index := bytes.LastIndex(bytes.ToLower(htmlContent), []byte("</body>"))
+	if index < 0 {
+		return append(htmlContent[:len(htmlContent):len(htmlContent)], script.Bytes()...)
+	}
+	output := make([]byte, 0, len(htmlContent)+script.Len())
+	output = append(output, htmlContent[:index]...)
+	output = append(output, script.Bytes()...)
+	return append(output, htmlContent[index:]...)
+}
+
+// liveReloadHandler streams a 'reload' server-sent event whenever the content snapshot is replaced. The stream ends
+// when the client goes away or the server context is cancelled, so that it does not hold up a graceful shutdown.
+func liveReloadHandler(ctx context.Context, content *contentStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != "GET" {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		controller := http.NewResponseController(writer)
+		// the stream lives much longer than the write timeout of the server
+		if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+			slog.Warn("failed to clear the write deadline for the live reload stream", "err", err)
+		}
+		writer.Header().Set("Content-Type", "text/event-stream")
+		writer.Header().Set("Cache-Control", "no-cache")
+		writer.WriteHeader(http.StatusOK)
+		_ = controller.Flush()
+
+		keepAlive := time.NewTicker(liveReloadKeepAlive)
+		defer keepAlive.Stop()
+		snap := content.Snapshot()
+		for {
+			var message string
+			select {
+			case <-ctx.Done():
+				return
+			case <-request.Context().Done():
+				return
+			case <-keepAlive.C:
+				message = ": keep-alive\n\n"
+			case <-snap.Replaced:
+				snap = content.Snapshot()
+				message = fmt.Sprintf("event: reload\ndata: %s\n\n", time.Now().UTC().Format(time.RFC3339))
+			}
+			if _, err := writer.Write([]byte(message)); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
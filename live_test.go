@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInjectLiveReloadScript(t *testing.T) {
+	output := string(injectLiveReloadScript([]byte("<html><body><p>x</p></BODY></html>"), "../_live"))
+	assert.True(t, strings.HasPrefix(output, "<html><body><p>x</p><script>\n"), output)
+	assert.True(t, strings.HasSuffix(output, "</script>\n</BODY></html>"), output)
+	assert.Contains(t, output, `new EventSource("../_live")`)
+
+	output = string(injectLiveReloadScript([]byte("<p>x</p>"), "_live"))
+	assert.True(t, strings.HasPrefix(output, "<p>x</p><script>\n"), output)
+}
+
+func TestRunLive(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# first\n"), 0600))
+	cssPath := filepath.Join(t.TempDir(), "some.css")
+	require.NoError(t, os.WriteFile(cssPath, []byte("body { color: red; }"), 0600))
+
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, CssUrl: cssPath, Live: true, WatchInterval: time.Millisecond * 50}, nil)
+
+	_, body := getBody(t, baseUrl+"/")
+	assert.Contains(t, body, `new EventSource("_live")`)
+
+	resp, err := http.Get(baseUrl + LiveReloadPath)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "event: ") {
+				events <- scanner.Text()
+			}
+		}
+	}()
+
+	require.NoError(t, os.WriteFile(cssPath, []byte("body { color: blue; }"), 0600))
+	select {
+	case event := <-events:
+		assert.Equal(t, "event: reload", event)
+	case <-time.After(time.Second * 5):
+		assert.Fail(t, "timed out waiting for the reload event")
+	}
+}
+
+func TestRunLive_disabled(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# first\n"), 0600))
+
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath}, nil)
+	_, body := getBody(t, baseUrl+"/")
+	assert.NotContains(t, body, "<script>")
+}
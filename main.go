@@ -2,36 +2,49 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"mime"
 	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
-
-	"github.com/russross/blackfriday"
 )
 
 const (
-	DefaultListenAddr  = "0.0.0.0:8080"
-	DefaultPageTitle   = "Landing page"
-	DefaultCssUrl      = ""
-	DefaultFaviconUrl  = ""
-	DefaultDebug       = false
-	DefaultUsagePrefix = `Usage: md-http [options...] <filepath>
+	DefaultListenAddr    = "0.0.0.0:8080"
+	DefaultPageTitle     = "Landing page"
+	DefaultCssUrl        = ""
+	DefaultFaviconUrl    = ""
+	DefaultTemplateFile  = ""
+	DefaultDebug         = false
+	DefaultWatch         = false
+	DefaultWatchInterval = time.Second * 2
+	DefaultLive          = false
+	DefaultEdit          = false
+	DefaultHistoryDir    = ""
+	DefaultMetricsListen = ""
+	DefaultAccessLog     = ""
+	DefaultTlsCert       = ""
+	DefaultTlsKey        = ""
+	DefaultUsagePrefix   = `Usage: md-http [options...] <filepath or directory>
+       md-http export [options...] <filepath or directory>
+       md-http healthcheck [options...]
 `
 	DefaultUsageSuffix = `
-All options also have an environment variable counterpart: MDHTTP_<option>=<value>.
+All options also have an environment variable counterpart: MDHTTP_<option>=<value>, with any dashes in the
+option written as underscores (MDHTTP_tls_cert).
 More details about this binary can be found at the source repo: https://github.com/astromechza/md-http.
 `
 )
@@ -47,46 +60,119 @@ func main() {
 // mainInner is the real interface entrypoint, but testable.
 // This defines the flags, validation, and parsing options.
 func mainInner(args []string, output io.Writer) error {
+	// subcommands are dispatched on the first argument, anything else runs the server
+	if len(args) > 1 {
+		switch args[1] {
+		case "export":
+			return mainExport(append([]string{args[0] + " export"}, args[2:]...), output)
+		case "healthcheck":
+			return mainHealthcheck(append([]string{args[0] + " healthcheck"}, args[2:]...), output)
+		}
+	}
+
 	parsedArgs, err := parse(args, output)
 	if err != nil {
 		return err
 	}
-	logOptions := &slog.HandlerOptions{
-		AddSource: parsedArgs.LogDebug,
-		Level:     map[bool]slog.Level{false: slog.LevelInfo, true: slog.LevelDebug}[parsedArgs.LogDebug],
-	}
-	if parsedArgs.LogJson {
-		slog.SetDefault(slog.New(slog.NewJSONHandler(output, logOptions)))
-	} else {
-		slog.SetDefault(slog.New(slog.NewTextHandler(output, logOptions)))
-	}
+	setupLogging(output, parsedArgs.LogDebug, parsedArgs.LogJson)
 
 	// open a context
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// wait until the context finishes and call cancel
+	// wait until an exit signal arrives and call cancel, or forward a hangup signal as a content reload
+	reload := make(chan struct{}, 1)
 	go func() {
-		exit := make(chan os.Signal, 1) // we need to reserve to buffer size 1, so the notifier are not blocked
-		signal.Notify(exit, syscall.SIGINT, syscall.SIGTERM)
+		signals := make(chan os.Signal, 1) // we need to reserve to buffer size 1, so the notifier are not blocked
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 		// Receive output from signalChan.
-		sig := <-exit
-		slog.Info("Signal caught, stopping context", "signal", sig.String())
-		cancel()
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				slog.Info("Signal caught, reloading content", "signal", sig.String())
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
+				continue
+			}
+			slog.Info("Signal caught, stopping context", "signal", sig.String())
+			cancel()
+			return
+		}
 	}()
 
-	return run(ctx, parsedArgs)
+	return run(ctx, parsedArgs, reload)
+}
+
+// setupLogging sets the default logger to write text or json logs to the output.
+func setupLogging(output io.Writer, debug, json bool) {
+	logOptions := &slog.HandlerOptions{
+		AddSource: debug,
+		Level:     map[bool]slog.Level{false: slog.LevelInfo, true: slog.LevelDebug}[debug],
+	}
+	if json {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(output, logOptions)))
+	} else {
+		slog.SetDefault(slog.New(slog.NewTextHandler(output, logOptions)))
+	}
 }
 
 type argsStruct struct {
-	AddrPort     netip.AddrPort
-	MarkdownFile string
-	PageTitle    string
-	CssUrl       string
-	FaviconUrl   string
-	LogDebug     bool
-	LogJson      bool
+	AddrPort      netip.AddrPort
+	MarkdownFile  string
+	PageTitle     string
+	CssUrl        string
+	FaviconUrl    string
+	TemplateFile  string
+	LogDebug      bool
+	LogJson       bool
+	Watch         bool
+	WatchInterval time.Duration
+	Live          bool
+	Edit          bool
+	HistoryDir    string
+	// MetricsAddrPort is the address of a separate listener for the metrics, when unset they are served on AddrPort.
+	MetricsAddrPort netip.AddrPort
+	AccessLogFormat string
+	AccessLogFile   string
+	// TrustedProxies are the peers whose forwarding headers are believed when working out the client address.
+	TrustedProxies []netip.Prefix
+	// TlsCertFile and TlsKeyFile switch the server to https, TlsRedirectAddrPort is an optional plain http listener
+	// that redirects to it.
+	TlsCertFile         string
+	TlsKeyFile          string
+	TlsMinVersion       uint16
+	TlsCipherSuites     []uint16
+	TlsRedirectAddrPort netip.AddrPort
+	// TlsClientCaFile requires clients to present a certificate issued by one of its authorities, and TlsClientAllow
+	// optionally restricts them to certificates with the listed subject common names or alternative names.
+	TlsClientCaFile string
+	TlsClientAllow  []string
+	// HtpasswdFile and BearerTokensFile require clients to authenticate, except on the AuthExempt paths.
+	HtpasswdFile     string
+	BearerTokensFile string
+	AuthExempt       []string
+	// OidcIssuer enables logging in through OpenID Connect, OidcRedirectUrl is the external url of /_auth/callback.
+	OidcIssuer         string
+	OidcClientId       string
+	OidcClientSecret   string
+	OidcRedirectUrl    string
+	OidcCookieSecret   string
+	OidcAllowedDomains []string
+	OidcAllowedGroups  []string
+	// IdentityProxies are the proxies trusted to identify the client in the X-Forwarded-User headers.
+	IdentityProxies []netip.Prefix
+	// AllowUsers and AllowGroups restrict the authenticated clients, however they were authenticated.
+	AllowUsers  []string
+	AllowGroups []string
+	// AllowCidrs and DenyCidrs filter the clients by address, see ipFilter.
+	AllowCidrs []netip.Prefix
+	DenyCidrs  []netip.Prefix
+	// RateLimit is the sustained number of requests per second allowed for each client, zero disables the limit.
+	RateLimit       float64
+	RateLimitBurst  int
+	RateLimitExempt []string
 }
 
 func parse(args []string, output io.Writer) (argsStruct, error) {
@@ -102,20 +188,58 @@ func parse(args []string, output io.Writer) (argsStruct, error) {
 	fs.BoolVar(&receiver.LogDebug, "debug", DefaultDebug, "Enable debug logging")
 	fs.BoolVar(&receiver.LogJson, "jsonlog", false, "Switch to structured json logging")
 	fs.StringVar(&receiver.FaviconUrl, "favicon", DefaultFaviconUrl, "An optional favicon file path or url (http:// or https://) to serve with the output")
+	fs.StringVar(&receiver.TemplateFile, "template", DefaultTemplateFile, "An optional html/template file path used to render the page instead of the built-in template")
+	fs.BoolVar(&receiver.Watch, "watch", DefaultWatch, "Watch the markdown file and reload it when it changes")
+	fs.DurationVar(&receiver.WatchInterval, "watch-interval", DefaultWatchInterval, "The polling interval used to detect file changes when watching")
+	fs.BoolVar(&receiver.Edit, "edit", DefaultEdit, "Enable editing the markdown in the browser at /_edit and through PUT requests guarded by If-Match")
+	fs.StringVar(&receiver.HistoryDir, "history-dir", DefaultHistoryDir, "An optional directory in which every edit is kept as a revision, browsable and restorable at /_history")
+	var metricsListenAddr string
+	fs.StringVar(&metricsListenAddr, "metrics-listen", DefaultMetricsListen, "An optional socket address to serve the Prometheus metrics on instead of at /metrics on the main listener")
+	fs.StringVar(&receiver.AccessLogFormat, "access-log-format", AccessLogSlog, "The format of the access log: "+strings.Join(accessLogFormats, ", "))
+	fs.StringVar(&receiver.AccessLogFile, "access-log", DefaultAccessLog, "An optional file to append the access log to instead of stdout, it is reopened on SIGUSR1 for log rotation")
+	var trustedProxies string
+	fs.StringVar(&trustedProxies, "trusted-proxies", "", "A comma separated list of proxy CIDRs or addresses whose X-Forwarded-For and Forwarded headers are trusted for the client address")
+	fs.StringVar(&receiver.TlsCertFile, "tls-cert", DefaultTlsCert, "An optional certificate file to serve https instead of http, it is reloaded when it changes")
+	fs.StringVar(&receiver.TlsKeyFile, "tls-key", DefaultTlsKey, "The private key file of the -tls-cert certificate")
+	var tlsMinVersion, tlsCipherSuites, tlsRedirectListenAddr string
+	fs.StringVar(&tlsMinVersion, "tls-min-version", DefaultTlsMinVersion, "The minimum tls version to accept: 1.0, 1.1, 1.2, or 1.3")
+	fs.StringVar(&tlsCipherSuites, "tls-cipher-suites", "", "An optional comma separated list of the cipher suites to allow for tls 1.2 and earlier, instead of the Go defaults")
+	fs.StringVar(&tlsRedirectListenAddr, "tls-redirect-listen", "", "An optional socket address for a plain http listener which redirects to https")
+	fs.StringVar(&receiver.TlsClientCaFile, "tls-client-ca", "", "An optional file of the certificate authorities that clients must present a certificate from, it is reloaded when it changes")
+	var tlsClientAllow string
+	fs.StringVar(&tlsClientAllow, "tls-client-allow", "", "An optional comma separated list of the client certificate common names or subject alternative names to allow")
+	fs.StringVar(&receiver.HtpasswdFile, "htpasswd", "", "An optional htpasswd file of bcrypt or SHA password hashes to require HTTP Basic authentication")
+	fs.StringVar(&receiver.BearerTokensFile, "bearer-tokens", "", "An optional file of bearer tokens, one 'name:token' per line, to accept as authentication")
+	var authExempt string
+	fs.StringVar(&authExempt, "auth-exempt", "", "A comma separated list of paths that do not require authentication, a path ending in '/' exempts everything below it, /healthz and /readyz are always exempt")
+	fs.StringVar(&receiver.OidcIssuer, "oidc-issuer", "", "An optional OpenID Connect issuer url to require browsers to log in through")
+	fs.StringVar(&receiver.OidcClientId, "oidc-client-id", "", "The client id registered with the -oidc-issuer")
+	fs.StringVar(&receiver.OidcClientSecret, "oidc-client-secret", "", "The client secret registered with the -oidc-issuer, preferably set through MDHTTP_oidc_client_secret")
+	fs.StringVar(&receiver.OidcRedirectUrl, "oidc-redirect-url", "", "The external url of "+OidcCallbackPath+" registered with the -oidc-issuer, such as https://docs.example.com"+OidcCallbackPath)
+	fs.StringVar(&receiver.OidcCookieSecret, "oidc-cookie-secret", "", "A secret to encrypt the session cookies with so that they survive restarts, preferably set through MDHTTP_oidc_cookie_secret")
+	var oidcAllowedDomains, oidcAllowedGroups string
+	fs.StringVar(&oidcAllowedDomains, "oidc-allowed-domains", "", "An optional comma separated list of the email domains that may log in")
+	fs.StringVar(&oidcAllowedGroups, "oidc-allowed-groups", "", "An optional comma separated list of the groups claim values that may log in")
+	var identityProxies, allowUsers, allowGroups string
+	fs.StringVar(&identityProxies, "identity-proxies", "", "An optional comma separated list of authenticating proxy CIDRs or addresses whose X-Forwarded-User, X-Forwarded-Email, and X-Forwarded-Groups headers identify the client, clients without an identity are refused")
+	fs.StringVar(&allowUsers, "allow-users", "", "An optional comma separated list of the user names or emails allowed once authenticated")
+	fs.StringVar(&allowGroups, "allow-groups", "", "An optional comma separated list of the groups allowed once authenticated")
+	fs.Var((*prefixesValue)(&receiver.AllowCidrs), "allow-cidr", "An optional `cidr` or comma separated list of CIDRs of the only clients to serve, it may be repeated")
+	fs.Var((*prefixesValue)(&receiver.DenyCidrs), "deny-cidr", "An optional `cidr` or comma separated list of CIDRs of clients to refuse, it may be repeated and takes precedence over -allow-cidr")
+	fs.Float64Var(&receiver.RateLimit, "rate-limit", 0, "An optional number of requests per second to allow each client, by authenticated user or address, 0 disables the limit")
+	fs.IntVar(&receiver.RateLimitBurst, "rate-limit-burst", DefaultRateLimitBurst, "The number of requests a client may make at once before -rate-limit applies")
+	var rateLimitExempt string
+	fs.StringVar(&rateLimitExempt, "rate-limit-exempt", "", "A comma separated list of paths that are not rate limited, a path ending in '/' exempts everything below it, /healthz and /readyz are always exempt")
+	fs.BoolVar(&receiver.Live, "live", DefaultLive, "Enable live preview, this implies -watch and injects a script into the page which reloads it when the files change")
 
 	fs.Usage = func() {
 		_, _ = fs.Output().Write([]byte(DefaultUsagePrefix))
 		fs.PrintDefaults()
 		_, _ = fs.Output().Write([]byte(DefaultUsageSuffix))
 	}
-	var err error
-	fs.VisitAll(func(f *flag.Flag) {
-		if value, ok := os.LookupEnv("MDHTTP_" + f.Name); ok {
-			if fErr := fs.Set(f.Name, value); fErr != nil {
-				err = fErr
-			}
-		}
-	})
+	if err := setFlagsFromEnv(fs); err != nil {
+		return *receiver, err
+	}
 	if err := fs.Parse(args[1:]); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return *receiver, http.ErrServerClosed
@@ -123,7 +247,7 @@ func parse(args []string, output io.Writer) (argsStruct, error) {
 		return *receiver, err
 	}
 	if fs.NArg() != 1 {
-		_, _ = fs.Output().Write([]byte("Expected a single argument as the markdown filepath!\n\n"))
+		_, _ = fs.Output().Write([]byte("Expected a single argument as the markdown filepath or directory!\n\n"))
 		fs.Usage()
 		return *receiver, http.ErrServerClosed
 	}
@@ -136,87 +260,229 @@ func parse(args []string, output io.Writer) (argsStruct, error) {
 		return *receiver, http.ErrServerClosed
 	}
 	receiver.AddrPort = addrPort
+
+	if metricsListenAddr != "" {
+		if receiver.MetricsAddrPort, err = netip.ParseAddrPort(metricsListenAddr); err != nil {
+			_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'metrics-listen' '%s'\n\n", metricsListenAddr)
+			fs.Usage()
+			return *receiver, http.ErrServerClosed
+		}
+	}
+
+	if !slices.Contains(accessLogFormats, receiver.AccessLogFormat) {
+		_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'access-log-format' '%s', must be one of %s\n\n", receiver.AccessLogFormat, strings.Join(accessLogFormats, ", "))
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	if receiver.TrustedProxies, err = parsePrefixes(trustedProxies); err != nil {
+		_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'trusted-proxies' '%s': %v\n\n", trustedProxies, err)
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+
+	if (receiver.TlsCertFile == "") != (receiver.TlsKeyFile == "") {
+		_, _ = fs.Output().Write([]byte("Both 'tls-cert' and 'tls-key' must be set to serve https\n\n"))
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	if receiver.TlsMinVersion, err = parseTlsVersion(tlsMinVersion); err != nil {
+		_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'tls-min-version' '%s', %v\n\n", tlsMinVersion, err)
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	if receiver.TlsCipherSuites, err = parseCipherSuites(tlsCipherSuites); err != nil {
+		_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'tls-cipher-suites': %v\n\n", err)
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	if receiver.TlsClientCaFile != "" && receiver.TlsCertFile == "" {
+		_, _ = fs.Output().Write([]byte("The 'tls-client-ca' option requires 'tls-cert' and 'tls-key'\n\n"))
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	receiver.TlsClientAllow = splitList(tlsClientAllow)
+	if len(receiver.TlsClientAllow) > 0 && receiver.TlsClientCaFile == "" {
+		_, _ = fs.Output().Write([]byte("The 'tls-client-allow' option requires 'tls-client-ca'\n\n"))
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	receiver.AuthExempt = splitList(authExempt)
+	receiver.OidcAllowedDomains = splitList(oidcAllowedDomains)
+	receiver.OidcAllowedGroups = splitList(oidcAllowedGroups)
+	if receiver.OidcIssuer != "" {
+		if receiver.OidcClientId == "" || receiver.OidcRedirectUrl == "" {
+			_, _ = fs.Output().Write([]byte("The 'oidc-issuer' option requires 'oidc-client-id' and 'oidc-redirect-url'\n\n"))
+			fs.Usage()
+			return *receiver, http.ErrServerClosed
+		}
+		if u, err := url.Parse(receiver.OidcRedirectUrl); err != nil || !u.IsAbs() || u.Path != OidcCallbackPath {
+			_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'oidc-redirect-url' '%s', must be an absolute url with the path %s\n\n", receiver.OidcRedirectUrl, OidcCallbackPath)
+			fs.Usage()
+			return *receiver, http.ErrServerClosed
+		}
+	} else if receiver.OidcClientId != "" || receiver.OidcRedirectUrl != "" || len(receiver.OidcAllowedDomains) > 0 || len(receiver.OidcAllowedGroups) > 0 {
+		_, _ = fs.Output().Write([]byte("The 'oidc-*' options require 'oidc-issuer'\n\n"))
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	if receiver.IdentityProxies, err = parsePrefixes(identityProxies); err != nil {
+		_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'identity-proxies' '%s': %v\n\n", identityProxies, err)
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	receiver.AllowUsers, receiver.AllowGroups = splitList(allowUsers), splitList(allowGroups)
+	if (len(receiver.AllowUsers) > 0 || len(receiver.AllowGroups) > 0) && !receiver.authenticates() {
+		_, _ = fs.Output().Write([]byte("The 'allow-users' and 'allow-groups' options require a way to authenticate clients\n\n"))
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	if receiver.RateLimit < 0 || math.IsNaN(receiver.RateLimit) || math.IsInf(receiver.RateLimit, 0) {
+		_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'rate-limit' '%v', must be zero or positive\n\n", receiver.RateLimit)
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	if receiver.RateLimitBurst < 1 {
+		_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'rate-limit-burst' '%d', must be positive\n\n", receiver.RateLimitBurst)
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
+	receiver.RateLimitExempt = splitList(rateLimitExempt)
+	if tlsRedirectListenAddr != "" {
+		if receiver.TlsCertFile == "" {
+			_, _ = fs.Output().Write([]byte("The 'tls-redirect-listen' option requires 'tls-cert' and 'tls-key'\n\n"))
+			fs.Usage()
+			return *receiver, http.ErrServerClosed
+		}
+		if receiver.TlsRedirectAddrPort, err = netip.ParseAddrPort(tlsRedirectListenAddr); err != nil {
+			_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'tls-redirect-listen' '%s'\n\n", tlsRedirectListenAddr)
+			fs.Usage()
+			return *receiver, http.ErrServerClosed
+		}
+	}
+
+	if receiver.WatchInterval <= 0 {
+		_, _ = fmt.Fprintf(fs.Output(), "Invalid value for 'watch-interval' '%s', must be positive\n\n", receiver.WatchInterval)
+		fs.Usage()
+		return *receiver, http.ErrServerClosed
+	}
 	return *receiver, nil
 }
 
-// run does the real logic of reading the file and running the server
-func run(ctx context.Context, parsedArgs argsStruct) error {
-	slog.Debug("reading markdown file", "path", parsedArgs.MarkdownFile)
-	raw, err := os.ReadFile(parsedArgs.MarkdownFile)
-	if err != nil {
-		return fmt.Errorf("failed to open the file: %w", err)
+// authenticates returns whether any of the ways to authenticate clients are enabled.
+func (a argsStruct) authenticates() bool {
+	return a.TlsClientCaFile != "" || a.HtpasswdFile != "" || a.BearerTokensFile != "" || a.OidcIssuer != "" || len(a.IdentityProxies) > 0
+}
+
+// splitList splits a comma separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
+
+// setFlagsFromEnv sets each flag that has an MDHTTP_<flag> environment variable, so that the command line still
+// takes precedence. Most shells and compose files can't export names with dashes, so the dashes in the flag name may
+// also be written as underscores, such as MDHTTP_tls_cert for -tls-cert.
+func setFlagsFromEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		for _, name := range []string{"MDHTTP_" + f.Name, "MDHTTP_" + strings.ReplaceAll(f.Name, "-", "_")} {
+			if value, ok := os.LookupEnv(name); ok {
+				if fErr := fs.Set(f.Name, value); fErr != nil {
+					err = fmt.Errorf("invalid value for '%s': %w", name, fErr)
+				}
+				break
+			}
+		}
+	})
+	return err
+}
 
-	if parsedArgs.CssUrl != "" && !strings.HasPrefix(parsedArgs.CssUrl, "http://") && !strings.HasPrefix(parsedArgs.CssUrl, "https://") {
-		parsedArgs.CssUrl = strings.TrimPrefix(parsedArgs.CssUrl, "file://")
-		slog.Debug("reading css file", "path", parsedArgs.CssUrl)
-		rawCss, err := os.ReadFile(parsedArgs.CssUrl)
-		if err != nil {
-			return fmt.Errorf("failed to read the css file: %v", err)
+// run does the real logic of reading the file and running the server. Sending on the reload channel re-reads all the
+// source files.
+func run(ctx context.Context, parsedArgs argsStruct, reload <-chan struct{}) error {
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Error("Failed to flush the remaining spans", "err", err)
 		}
-		http.HandleFunc("/default.css", func(writer http.ResponseWriter, request *http.Request) {
+	}()
+
+	mux := http.NewServeMux()
+	content := &contentStore{
+		MarkdownPath: parsedArgs.MarkdownFile,
+		TemplatePath: parsedArgs.TemplateFile,
+		PageTitle:    parsedArgs.PageTitle,
+		LiveReload:   parsedArgs.Live,
+	}
+
+	content.SetAssets(parsedArgs.CssUrl, parsedArgs.FaviconUrl)
+	if content.CssPath != "" {
+		mux.HandleFunc("/"+content.CssUrl, func(writer http.ResponseWriter, request *http.Request) {
 			if request.Method != "GET" {
 				writer.WriteHeader(http.StatusMethodNotAllowed)
 				return
 			}
-			writer.Header().Set("Content-Type", "text/css; charset=utf-8")
-			_, _ = writer.Write(rawCss)
+			writeRepresentation(writer, request, content.Snapshot().CssVariant)
 		})
-		parsedArgs.CssUrl = "default.css"
 	}
-
-	if parsedArgs.FaviconUrl != "" && !strings.HasPrefix(parsedArgs.FaviconUrl, "http://") && !strings.HasPrefix(parsedArgs.FaviconUrl, "https://") {
-		parsedArgs.FaviconUrl = strings.TrimPrefix(parsedArgs.FaviconUrl, "file://")
-		slog.Debug("reading favicon file", "path", parsedArgs.FaviconUrl)
-		rawIcon, err := os.ReadFile(parsedArgs.FaviconUrl)
-		if err != nil {
-			return fmt.Errorf("failed to read the favicon file: %v", err)
-		}
-		ext := filepath.Ext(parsedArgs.FaviconUrl)
-		parsedArgs.FaviconUrl = "default-favicon" + ext
-		http.HandleFunc("/"+parsedArgs.FaviconUrl, func(writer http.ResponseWriter, request *http.Request) {
+	if content.FaviconPath != "" {
+		mux.HandleFunc("/"+content.FaviconUrl, func(writer http.ResponseWriter, request *http.Request) {
 			if request.Method != "GET" {
 				writer.WriteHeader(http.StatusMethodNotAllowed)
 				return
 			}
-			writer.Header().Set("Content-Type", mime.TypeByExtension(ext))
-			_, _ = writer.Write(rawIcon)
+			writer.Header().Set("Content-Type", mime.TypeByExtension(filepath.Ext(content.FaviconPath)))
+			_, _ = writer.Write(content.Snapshot().Favicon)
 		})
 	}
+	if parsedArgs.HistoryDir != "" {
+		if err := os.MkdirAll(parsedArgs.HistoryDir, 0755); err != nil {
+			return fmt.Errorf("failed to create the history directory: %w", err)
+		}
+		content.History = &historyStore{Dir: parsedArgs.HistoryDir}
+	}
+	if err := content.Load(); err != nil {
+		return err
+	}
+	var certs *certificateStore
+	if parsedArgs.TlsCertFile != "" {
+		certs = &certificateStore{CertPath: parsedArgs.TlsCertFile, KeyPath: parsedArgs.TlsKeyFile, ClientCaPath: parsedArgs.TlsClientCaFile}
+		if err := certs.Load(); err != nil {
+			return err
+		}
+		go watchFiles(ctx, certs.Paths, parsedArgs.WatchInterval, certs.Reload)
+	}
+	if parsedArgs.Watch || parsedArgs.Live {
+		slog.Info("Watching files for changes", "paths", content.Paths(), "interval", parsedArgs.WatchInterval)
+		go watchFiles(ctx, content.Paths, parsedArgs.WatchInterval, content.Reload)
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reload:
+				slog.Info("Reloading content")
+				content.Reload()
+			}
+		}
+	}()
+
+	if parsedArgs.Live {
+		mux.HandleFunc(LiveReloadPath, liveReloadHandler(ctx, content))
+	}
 
-	slog.Debug("converting markdown to html")
-	htmlContent := blackfriday.Markdown(
-		raw,
-		blackfriday.HtmlRenderer(
-			// common defaults
-			blackfriday.HTML_USE_XHTML|
-				blackfriday.HTML_USE_SMARTYPANTS|
-				blackfriday.HTML_SMARTYPANTS_FRACTIONS|
-				blackfriday.HTML_SMARTYPANTS_DASHES|
-				blackfriday.HTML_SMARTYPANTS_LATEX_DASHES|
-				// extras
-				blackfriday.HTML_COMPLETE_PAGE|
-				blackfriday.HTML_FOOTNOTE_RETURN_LINKS|
-				blackfriday.HTML_HREF_TARGET_BLANK,
-			parsedArgs.PageTitle,
-			parsedArgs.CssUrl,
-		),
-		// defaults
-		blackfriday.EXTENSION_NO_INTRA_EMPHASIS|
-			blackfriday.EXTENSION_TABLES|
-			blackfriday.EXTENSION_FENCED_CODE|
-			blackfriday.EXTENSION_AUTOLINK|
-			blackfriday.EXTENSION_STRIKETHROUGH|
-			blackfriday.EXTENSION_SPACE_HEADERS|
-			blackfriday.EXTENSION_HEADER_IDS|
-			blackfriday.EXTENSION_BACKSLASH_LINE_BREAK|
-			blackfriday.EXTENSION_DEFINITION_LISTS|
-			// extras
-			blackfriday.EXTENSION_FOOTNOTES|
-			blackfriday.EXTENSION_AUTO_HEADER_IDS,
-	)
-
-	http.HandleFunc("/healthz", func(writer http.ResponseWriter, request *http.Request) {
+	mux.HandleFunc("/healthz", func(writer http.ResponseWriter, request *http.Request) {
 		if request.Method != "GET" {
 			writer.WriteHeader(http.StatusMethodNotAllowed)
 			return
@@ -225,45 +491,233 @@ func run(ctx context.Context, parsedArgs argsStruct) error {
 		_, _ = writer.Write([]byte("healthz check passed"))
 	})
 
-	http.HandleFunc("/favicon.ico", func(writer http.ResponseWriter, request *http.Request) {
+	mux.HandleFunc("/readyz", readinessHandler(content))
+
+	mux.HandleFunc("/favicon.ico", func(writer http.ResponseWriter, request *http.Request) {
 		if request.Method != "GET" {
 			writer.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		if parsedArgs.FaviconUrl != "" {
-			writer.Header().Set("Location", parsedArgs.FaviconUrl)
+		if content.FaviconUrl != "" {
+			writer.Header().Set("Location", content.FaviconUrl)
 			writer.WriteHeader(http.StatusTemporaryRedirect)
 			return
 		}
 		writer.WriteHeader(http.StatusNotFound)
 	})
 
-	hashString := fmt.Sprintf("%x", sha256.Sum256(htmlContent))
-	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		if request.Method != "GET" {
+	if parsedArgs.Edit {
+		mux.HandleFunc(EditPath, editHandler(content))
+	}
+	if content.History != nil {
+		mux.HandleFunc(HistoryPath, historyHandler(content, parsedArgs.Edit))
+		mux.HandleFunc(HistoryPath+"/", historyHandler(content, parsedArgs.Edit))
+	}
+
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != "GET" && (request.Method != "PUT" || !parsedArgs.Edit) {
 			writer.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		if v := request.Header.Get("If-Match"); v != "" && v != hashString {
-			writer.WriteHeader(http.StatusPreconditionFailed)
+		// load the snapshot once so that the whole response is consistent even if a reload happens concurrently
+		snap := content.Snapshot()
+		if strings.HasSuffix(request.URL.Path, ".md") && request.Method == "GET" {
+			if current, ok := snap.PageForSource(request.URL.Path); ok {
+				writeRepresentation(writer, request, current.Variants["text/markdown"])
+				return
+			}
+		}
+		current, ok := snap.Pages[request.URL.Path]
+		if !ok && snap.Fallback != nil {
+			current = snap.Fallback
+		} else if !ok {
+			if _, ok := snap.Pages[request.URL.Path+"/"]; ok && request.Method == "GET" {
+				http.Redirect(writer, request, request.URL.Path+"/", http.StatusMovedPermanently)
+				return
+			}
+			http.NotFound(writer, request)
 			return
-		} else if v := request.Header.Get("If-None-Match"); v != "" && v == hashString {
-			writer.Header().Set("Content-Length", strconv.Itoa(len(htmlContent)))
-			writer.Header().Set("Content-Type", "text/html; charset=utf-8")
-			writer.WriteHeader(http.StatusNotModified)
+		}
+		if request.Method == "PUT" {
+			putPage(content, current, writer, request)
 			return
 		}
-		writer.Header().Set("Etag", hashString)
-		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_, _ = writer.Write(htmlContent)
+		// the variant is chosen by the format query parameter, otherwise it is negotiated from the Accept header
+		var mediaType string
+		if format := request.URL.Query().Get("format"); format != "" {
+			if mediaType, ok = formatMediaTypes[format]; !ok {
+				http.Error(writer, fmt.Sprintf("unsupported format '%s'", format), http.StatusBadRequest)
+				return
+			}
+		} else {
+			writer.Header().Set("Vary", "Accept, User-Agent")
+			offers := variantOrder
+			if isTerminalClient(request.UserAgent()) {
+				offers = terminalVariantOrder
+			}
+			if mediaType = negotiateContentType(request.Header.Get("Accept"), offers); mediaType == "" {
+				mediaType = offers[0]
+			}
+		}
+		if color, _ := strconv.ParseBool(request.URL.Query().Get("color")); color && mediaType == "text/plain" {
+			mediaType = ansiVariant
+		}
+		rep := current.Variants[mediaType]
+		if id := requestIdentity(request); id != nil && mediaType == "text/html" && current.Personalize != nil {
+			var err error
+			if rep, err = current.Personalize(id); err != nil {
+				slog.Error("failed to render the page for the user", "route", current.Route, "err", err)
+				http.Error(writer, "failed to render the page", http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Cache-Control", "private")
+		}
+		writeRepresentation(writer, request, rep)
 	})
 
+	accessLog, err := newAccessLogger(parsedArgs.AccessLogFormat, parsedArgs.AccessLogFile, parsedArgs.LogJson)
+	if err != nil {
+		return err
+	}
+	defer accessLog.Close()
+	if parsedArgs.AccessLogFile != "" && len(reopenSignals) > 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, reopenSignals...)
+		defer signal.Stop(signals)
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case sig := <-signals:
+					slog.Info("Signal caught, reopening the access log", "signal", sig.String(), "path", parsedArgs.AccessLogFile)
+					if err := accessLog.Reopen(); err != nil {
+						slog.Error("Failed to reopen the access log", "err", err)
+					}
+				}
+			}
+		}()
+	}
+
+	var auth *authenticator
+	if parsedArgs.authenticates() {
+		auth = &authenticator{
+			Exempt:          parsedArgs.AuthExempt,
+			TrustedProxies:  parsedArgs.TrustedProxies,
+			Failures:        &failureLimiter{Limit: DefaultAuthFailureLimit, Window: DefaultAuthFailureWindow},
+			IdentityProxies: parsedArgs.IdentityProxies,
+			AllowUsers:      parsedArgs.AllowUsers,
+			AllowGroups:     parsedArgs.AllowGroups,
+		}
+		if parsedArgs.HtpasswdFile != "" {
+			if auth.Htpasswd, err = loadHtpasswd(parsedArgs.HtpasswdFile); err != nil {
+				return err
+			}
+		}
+		if parsedArgs.BearerTokensFile != "" {
+			if auth.Tokens, err = loadBearerTokens(parsedArgs.BearerTokensFile); err != nil {
+				return err
+			}
+		}
+		if parsedArgs.OidcIssuer != "" {
+			if auth.Oidc, err = newOidcLogin(ctx, parsedArgs.OidcIssuer, parsedArgs.OidcClientId, parsedArgs.OidcClientSecret, parsedArgs.OidcRedirectUrl, parsedArgs.OidcCookieSecret); err != nil {
+				return err
+			}
+			auth.Oidc.AllowedDomains = parsedArgs.OidcAllowedDomains
+			auth.Oidc.AllowedGroups = parsedArgs.OidcAllowedGroups
+			mux.HandleFunc(OidcCallbackPath, auth.Oidc.CallbackHandler())
+			mux.HandleFunc(OidcLogoutPath, auth.Oidc.LogoutHandler())
+		}
+	}
+
+	requestMetrics := newMetrics(content)
+	if parsedArgs.MetricsAddrPort.IsValid() {
+		metricsMux := http.NewServeMux()
+		metricsMux.HandleFunc(MetricsPath, metricsHandler(requestMetrics))
+		serveInBackground(ctx, &http.Server{
+			Addr:         parsedArgs.MetricsAddrPort.String(),
+			Handler:      metricsMux,
+			ReadTimeout:  time.Second * 10,
+			WriteTimeout: time.Second * 10,
+		}, "metrics")
+	} else {
+		mux.HandleFunc(MetricsPath, metricsHandler(requestMetrics))
+	}
+
+	var filter *ipFilter
+	if len(parsedArgs.AllowCidrs) > 0 || len(parsedArgs.DenyCidrs) > 0 {
+		filter = &ipFilter{Allow: parsedArgs.AllowCidrs, Deny: parsedArgs.DenyCidrs, TrustedProxies: parsedArgs.TrustedProxies}
+	}
+
+	var limiter *rateLimiter
+	if parsedArgs.RateLimit > 0 {
+		limiter = &rateLimiter{
+			Rate:           parsedArgs.RateLimit,
+			Burst:          parsedArgs.RateLimitBurst,
+			Exempt:         parsedArgs.RateLimitExempt,
+			TrustedProxies: parsedArgs.TrustedProxies,
+		}
+	}
+
 	server := &http.Server{
 		Addr: parsedArgs.AddrPort.String(),
 		Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			start := time.Now()
+			if request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+				request = withIdentity(request, certificateIdentity(request.TLS.VerifiedChains[0][0]))
+			}
+			route := metricsRoute(mux, content, request)
+			request, span := startServerSpan(request, route)
 			recorder := &responseRecorder{Inner: writer, StatusCode: http.StatusOK}
-			http.DefaultServeMux.ServeHTTP(recorder, request)
-			slog.Info("response", "method", request.Method, "uri", request.RequestURI, "status", recorder.StatusCode, "bytes", recorder.Written)
+			authorized := true
+			// the ip filter comes first, but like authentication it leaves the probes alone
+			if filter != nil && !probe(request) {
+				if addr, reason := filter.Check(request); reason != "" {
+					slog.Warn("request refused by the ip filter", "client", addr.String(), "reason", reason, "uri", request.RequestURI)
+					requestMetrics.Refuse(reason)
+					http.Error(recorder, "forbidden", http.StatusForbidden)
+					authorized = false
+				}
+			}
+			if authorized && auth != nil {
+				request, authorized = auth.Authorize(recorder, request)
+			}
+			// the limiter comes after authentication so that authenticated clients are limited by their identity
+			if authorized && limiter != nil {
+				authorized = limiter.Allow(recorder, request)
+			}
+			if authorized {
+				mux.ServeHTTP(recorder, request)
+			}
+			endServerSpan(span, recorder)
+			entry := accessLogEntry{
+				Time:        start,
+				RemoteAddr:  clientHost(request, parsedArgs.TrustedProxies),
+				Method:      request.Method,
+				Uri:         request.RequestURI,
+				Proto:       request.Proto,
+				Status:      recorder.StatusCode,
+				Bytes:       recorder.Written,
+				Duration:    time.Since(start).Seconds(),
+				Referer:     request.Referer(),
+				UserAgent:   request.UserAgent(),
+				Etag:        recorder.Header().Get("Etag"),
+				NotModified: recorder.StatusCode == http.StatusNotModified,
+			}
+			if id := requestIdentity(request); id != nil {
+				entry.User = id.Name
+			} else if user, _, ok := request.BasicAuth(); ok {
+				entry.User = user
+			}
+			if span.SpanContext().HasTraceID() {
+				entry.TraceId = span.SpanContext().TraceID().String()
+			}
+			accessLog.Log(entry)
+			requestMetrics.Observe(requestLabels{
+				Route:  route,
+				Method: request.Method,
+				Status: strconv.Itoa(recorder.StatusCode),
+			}, recorder.Written, time.Since(start), request.Header.Get("If-None-Match") != "")
 		}),
 		IdleTimeout:  time.Second * 30,
 		ReadTimeout:  time.Second * 10,
@@ -276,8 +730,69 @@ func run(ctx context.Context, parsedArgs argsStruct) error {
 			slog.Error("Failure during shutdown", "err", err)
 		}
 	}()
-	slog.Info("Starting http server", "listen", "http://"+parsedArgs.AddrPort.String())
-	return server.ListenAndServe()
+	if certs == nil {
+		slog.Info("Starting http server", "listen", "http://"+parsedArgs.AddrPort.String())
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = newTlsConfig(certs, parsedArgs.TlsMinVersion, parsedArgs.TlsCipherSuites, parsedArgs.TlsClientAllow)
+	if parsedArgs.TlsRedirectAddrPort.IsValid() {
+		serveInBackground(ctx, &http.Server{
+			Addr:         parsedArgs.TlsRedirectAddrPort.String(),
+			Handler:      httpsRedirectHandler(parsedArgs.AddrPort.Port()),
+			ReadTimeout:  time.Second * 10,
+			WriteTimeout: time.Second * 10,
+		}, "https redirect")
+	}
+	slog.Info("Starting https server", "listen", "https://"+parsedArgs.AddrPort.String())
+	return server.ListenAndServeTLS("", "")
+}
+
+// serveInBackground runs an additional plain http server until the context is cancelled. Failures are logged rather
+// than stopping the main server.
+func serveInBackground(ctx context.Context, server *http.Server, name string) {
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			slog.Error("Failure during shutdown", "server", name, "err", err)
+		}
+	}()
+	go func() {
+		slog.Info("Starting "+name+" server", "listen", "http://"+server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server failed", "server", name, "err", err)
+		}
+	}()
+}
+
+// writeRepresentation writes the representation of a page in the content coding negotiated from the Accept-Encoding
+// header, honouring the If-Match and If-None-Match headers.
+func writeRepresentation(writer http.ResponseWriter, request *http.Request, rep representation) {
+	if v := request.Header.Get("If-Match"); v != "" && etagHash(v) != rep.Hash {
+		writer.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	body, encoding := rep.Body, ""
+	if rep.Compressible() {
+		writer.Header().Add("Vary", "Accept-Encoding")
+		if acceptEncoding := request.Header.Get("Accept-Encoding"); acceptEncoding != "" {
+			encoded := rep.Encoded()
+			if encoding = negotiateEncoding(acceptEncoding, encoded); encoding != "" {
+				body = encoded[encoding]
+				writer.Header().Set("Content-Encoding", encoding)
+			}
+		}
+	}
+	etag := encodedEtag(rep.Hash, encoding)
+	if v := request.Header.Get("If-None-Match"); v != "" && v == etag {
+		writer.Header().Set("Etag", etag)
+		writer.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		writer.Header().Set("Content-Type", rep.ContentType)
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writer.Header().Set("Etag", etag)
+	writer.Header().Set("Content-Type", rep.ContentType)
+	_, _ = writer.Write(body)
 }
 
 type responseRecorder struct {
@@ -296,6 +811,11 @@ func (r *responseRecorder) Write(bytes []byte) (int, error) {
 	return c, err
 }
 
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.Inner
+}
+
 func (r *responseRecorder) WriteHeader(statusCode int) {
 	r.StatusCode = statusCode
 	r.Inner.WriteHeader(statusCode)
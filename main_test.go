@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"net/netip"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -55,7 +57,7 @@ func TestRunNominal(t *testing.T) {
 			AddrPort:     addrPort,
 			PageTitle:    "some title",
 			MarkdownFile: mdPath, CssUrl: cssPath, FaviconUrl: faviconPath,
-		}), http.ErrServerClosed.Error())
+		}, nil), http.ErrServerClosed.Error())
 	}()
 
 	for {
@@ -69,25 +71,28 @@ func TestRunNominal(t *testing.T) {
 	}
 
 	t.Run("test main", func(t *testing.T) {
-		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/", port))
+		req, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d/", port), nil)
+		req.Header.Set("Accept-Encoding", "identity")
+		resp, err := http.DefaultClient.Do(req)
 		require.NoError(t, err)
 		defer resp.Body.Close()
 
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
 		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
-		assert.Equal(t, "442", resp.Header.Get("Content-Length"))
+		assert.Equal(t, "338", resp.Header.Get("Content-Length"))
 
 		data, _ := io.ReadAll(resp.Body)
-		assert.Contains(t, string(data), `<!DOCTYPE html PUBLIC`)
+		assert.Contains(t, string(data), "<!DOCTYPE html>\n")
 		assert.Contains(t, string(data), `<title>some title</title>`)
 		assert.Contains(t, string(data), `<h1 id="example-header">example header</h1>`)
-		assert.Contains(t, string(data), `<link rel="stylesheet" type="text/css" href="default.css" />`)
-		assert.Equal(t, "4e0699512fce641ef614fa9f9dbb71a85c3eb7f99d8cbe1bfd5399f11e75927a", resp.Header.Get("Etag"))
+		assert.Contains(t, string(data), `<link rel="stylesheet" type="text/css" href="default.css">`)
+		assert.Contains(t, string(data), `<link rel="icon" href="default-favicon.png">`)
+		assert.Equal(t, "5f4c4ade1409c830ddf1b39bf4202cf795a26fc926016feb5485d4b06a21a23d", resp.Header.Get("Etag"))
 	})
 
 	t.Run("test if-match", func(t *testing.T) {
 		req, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d/", port), nil)
-		req.Header.Set("If-Match", "4e0699512fce641ef614fa9f9dbb71a85c3eb7f99d8cbe1bfd5399f11e75927a")
+		req.Header.Set("If-Match", "5f4c4ade1409c830ddf1b39bf4202cf795a26fc926016feb5485d4b06a21a23d")
 		resp, err := http.DefaultClient.Do(req)
 		require.NoError(t, err)
 		defer resp.Body.Close()
@@ -103,7 +108,8 @@ func TestRunNominal(t *testing.T) {
 
 	t.Run("test if-none-match", func(t *testing.T) {
 		req, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%d/", port), nil)
-		req.Header.Set("If-None-Match", "4e0699512fce641ef614fa9f9dbb71a85c3eb7f99d8cbe1bfd5399f11e75927a")
+		req.Header.Set("If-None-Match", "5f4c4ade1409c830ddf1b39bf4202cf795a26fc926016feb5485d4b06a21a23d")
+		req.Header.Set("Accept-Encoding", "identity")
 		resp, err := http.DefaultClient.Do(req)
 		require.NoError(t, err)
 		defer resp.Body.Close()
@@ -175,9 +181,13 @@ func TestParse_minimum(t *testing.T) {
 	args, err := parse([]string{"binary", mdPath}, buff)
 	assert.NoError(t, err)
 	assert.Equal(t, argsStruct{
-		PageTitle:    "Landing page",
-		MarkdownFile: mdPath,
-		AddrPort:     netip.AddrPortFrom(netip.AddrFrom4([4]byte{0, 0, 0, 0}), 8080),
+		PageTitle:       "Landing page",
+		MarkdownFile:    mdPath,
+		AddrPort:        netip.AddrPortFrom(netip.AddrFrom4([4]byte{0, 0, 0, 0}), 8080),
+		WatchInterval:   DefaultWatchInterval,
+		AccessLogFormat: AccessLogSlog,
+		TlsMinVersion:   tls.VersionTLS12,
+		RateLimitBurst:  DefaultRateLimitBurst,
 	}, args)
 }
 
@@ -192,12 +202,16 @@ func TestParse_all(t *testing.T) {
 	args, err := parse([]string{"binary", "-css", cssPath, "-debug", "-title", "Thing", "-listen", "127.0.0.1:8090", "-jsonlog", mdPath}, buff)
 	assert.NoError(t, err)
 	assert.Equal(t, argsStruct{
-		PageTitle:    "Thing",
-		MarkdownFile: mdPath,
-		CssUrl:       cssPath,
-		AddrPort:     netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 8090),
-		LogDebug:     true,
-		LogJson:      true,
+		PageTitle:       "Thing",
+		MarkdownFile:    mdPath,
+		CssUrl:          cssPath,
+		AddrPort:        netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 8090),
+		LogDebug:        true,
+		LogJson:         true,
+		WatchInterval:   DefaultWatchInterval,
+		AccessLogFormat: AccessLogSlog,
+		TlsMinVersion:   tls.VersionTLS12,
+		RateLimitBurst:  DefaultRateLimitBurst,
 	}, args)
 }
 
@@ -219,11 +233,210 @@ func TestParse_env(t *testing.T) {
 	args, err := parse([]string{"binary", mdPath}, buff)
 	assert.NoError(t, err)
 	assert.Equal(t, argsStruct{
-		PageTitle:    "Thing",
-		MarkdownFile: mdPath,
-		CssUrl:       cssPath,
-		AddrPort:     netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 8090),
-		LogDebug:     true,
-		LogJson:      true,
+		PageTitle:       "Thing",
+		MarkdownFile:    mdPath,
+		CssUrl:          cssPath,
+		AddrPort:        netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 8090),
+		LogDebug:        true,
+		LogJson:         true,
+		WatchInterval:   DefaultWatchInterval,
+		AccessLogFormat: AccessLogSlog,
+		TlsMinVersion:   tls.VersionTLS12,
+		RateLimitBurst:  DefaultRateLimitBurst,
 	}, args)
 }
+
+func TestParse_envUnderscores(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example header\n\n"), 0400))
+
+	defer os.Clearenv()
+	require.NoError(t, os.Setenv("MDHTTP_oidc_issuer", "https://accounts.example.com"))
+	require.NoError(t, os.Setenv("MDHTTP_oidc_client_id", "md-http"))
+	require.NoError(t, os.Setenv("MDHTTP_oidc_client_secret", "client-secret"))
+	require.NoError(t, os.Setenv("MDHTTP_oidc_redirect_url", "https://docs.example.com"+OidcCallbackPath))
+	require.NoError(t, os.Setenv("MDHTTP_oidc_cookie_secret", "cookie-secret"))
+	require.NoError(t, os.Setenv("MDHTTP_tls_cert", "tls.crt"))
+	require.NoError(t, os.Setenv("MDHTTP_tls-key", "tls.key"))
+
+	args, err := parse([]string{"binary", mdPath}, new(bytes.Buffer))
+	require.NoError(t, err)
+	assert.Equal(t, "https://accounts.example.com", args.OidcIssuer)
+	assert.Equal(t, "md-http", args.OidcClientId)
+	assert.Equal(t, "client-secret", args.OidcClientSecret)
+	assert.Equal(t, "https://docs.example.com"+OidcCallbackPath, args.OidcRedirectUrl)
+	assert.Equal(t, "cookie-secret", args.OidcCookieSecret)
+	assert.Equal(t, "tls.crt", args.TlsCertFile)
+	assert.Equal(t, "tls.key", args.TlsKeyFile)
+
+	require.NoError(t, os.Setenv("MDHTTP_rate_limit", "nope"))
+	_, err = parse([]string{"binary", mdPath}, new(bytes.Buffer))
+	assert.ErrorContains(t, err, "invalid value for 'MDHTTP_rate_limit'")
+}
+
+// startRun starts the server in the background with the given args, on a free port unless one is set, and returns the
+// base url. The server is stopped when the test finishes.
+func startRun(t *testing.T, args argsStruct, reload <-chan struct{}) string {
+	ctx, cancel := context.WithCancel(context.Background())
+	if !args.AddrPort.IsValid() {
+		port, err := freePort()
+		require.NoError(t, err)
+		args.AddrPort = netip.MustParseAddrPort(fmt.Sprintf("127.0.0.1:%d", port))
+	}
+	if args.WatchInterval == 0 {
+		args.WatchInterval = DefaultWatchInterval
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- run(ctx, args, reload)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.ErrorIs(t, <-done, http.ErrServerClosed)
+	})
+
+	baseUrl := "http://" + args.AddrPort.String()
+	for {
+		resp, err := http.Get(baseUrl + "/healthz")
+		if err == nil {
+			_ = resp.Body.Close()
+			return baseUrl
+		}
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+}
+
+func getBody(t *testing.T, url string) (*http.Response, string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(data)
+}
+
+func TestRunWatch(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# first\n"), 0600))
+
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, Watch: true, WatchInterval: time.Millisecond * 50}, nil)
+	resp, body := getBody(t, baseUrl+"/")
+	assert.Contains(t, body, "<h1 id=\"first\">first</h1>")
+	firstEtag := resp.Header.Get("Etag")
+
+	require.NoError(t, os.WriteFile(mdPath, []byte("# second header\n"), 0600))
+	assert.Eventually(t, func() bool {
+		_, body := getBody(t, baseUrl+"/")
+		return strings.Contains(body, "<h1 id=\"second-header\">second header</h1>")
+	}, time.Second*5, time.Millisecond*20)
+	resp, _ = getBody(t, baseUrl+"/")
+	assert.NotEqual(t, firstEtag, resp.Header.Get("Etag"))
+
+	// a vanished file keeps the last good version
+	require.NoError(t, os.Remove(mdPath))
+	time.Sleep(time.Millisecond * 300)
+	resp, body = getBody(t, baseUrl+"/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "second header")
+}
+
+func TestRunReload(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# first\n"), 0600))
+	cssPath := filepath.Join(t.TempDir(), "some.css")
+	require.NoError(t, os.WriteFile(cssPath, []byte("body { color: red; }"), 0600))
+	faviconPath := filepath.Join(t.TempDir(), "some.png")
+	require.NoError(t, os.WriteFile(faviconPath, []byte("a"), 0600))
+
+	reload := make(chan struct{})
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, CssUrl: cssPath, FaviconUrl: faviconPath}, reload)
+
+	require.NoError(t, os.WriteFile(mdPath, []byte("# second\n"), 0600))
+	require.NoError(t, os.WriteFile(cssPath, []byte("body { color: blue; }"), 0600))
+	require.NoError(t, os.WriteFile(faviconPath, []byte("b"), 0600))
+	_, body := getBody(t, baseUrl+"/")
+	assert.Contains(t, body, "first")
+
+	reload <- struct{}{}
+	assert.Eventually(t, func() bool {
+		_, body := getBody(t, baseUrl+"/")
+		return strings.Contains(body, "second")
+	}, time.Second*5, time.Millisecond*20)
+	_, body = getBody(t, baseUrl+"/default.css")
+	assert.Equal(t, "body { color: blue; }", body)
+	_, body = getBody(t, baseUrl+"/default-favicon.png")
+	assert.Equal(t, "b", body)
+
+	// a failed reload keeps everything as it was
+	require.NoError(t, os.Remove(cssPath))
+	require.NoError(t, os.WriteFile(mdPath, []byte("# third\n"), 0600))
+	reload <- struct{}{}
+	reload <- struct{}{}
+	_, body = getBody(t, baseUrl+"/")
+	assert.Contains(t, body, "second")
+}
+
+func TestRunDirectory(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "foo"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("# index\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo", "index.md"), []byte("# foo\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo", "bar.md"), []byte("# bar\n"), 0600))
+
+	baseUrl := startRun(t, argsStruct{MarkdownFile: dir}, nil)
+
+	resp, body := getBody(t, baseUrl+"/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, ">index</h1>")
+	rootEtag := resp.Header.Get("Etag")
+
+	resp, body = getBody(t, baseUrl+"/foo/bar")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, ">bar</h1>")
+	assert.NotEqual(t, rootEtag, resp.Header.Get("Etag"))
+
+	resp, body = getBody(t, baseUrl+"/foo/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, ">foo</h1>")
+
+	resp, _ = getBody(t, baseUrl+"/missing")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, baseUrl+"/foo", nil)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "/foo/", resp.Header.Get("Location"))
+
+	resp, _ = getBody(t, baseUrl+"/healthz")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRunTemplate(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example header\n\n## sub header\n"), 0600))
+	templatePath := filepath.Join(t.TempDir(), "page.html")
+	require.NoError(t, os.WriteFile(templatePath, []byte(`<html><title>{{ .Title }}</title><link href="{{ .CssUrl }}"><aside>{{ .TableOfContents }}</aside>{{ .Body }}</html>`), 0600))
+
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, PageTitle: "Custom <title>", CssUrl: "https://example.com/a.css", TemplateFile: templatePath}, nil)
+	resp, body := getBody(t, baseUrl+"/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `<html><title>Custom &lt;title&gt;</title><link href="https://example.com/a.css"><aside><nav>
+<ul>
+<li><a href="#example-header">example header</a>
+<ul>
+<li><a href="#sub-header">sub header</a></li>
+</ul></li>
+</ul>
+</nav>
+</aside><h1 id="example-header">example header</h1>
+
+<h2 id="sub-header">sub header</h2>
+</html>`, body)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsPath is the route of the Prometheus metrics.
+const MetricsPath = "/metrics"
+
+// durationBuckets are the upper bounds of the request duration histogram in seconds.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestLabels are the labels of the request metrics. The route is the page or handler that served the request
+// rather than the requested path, which keeps the number of series bounded.
+type requestLabels struct {
+	Route  string
+	Method string
+	Status string
+}
+
+// requestStats are the accumulated metrics of the requests with the same labels.
+type requestStats struct {
+	Count       uint64
+	Bytes       uint64
+	DurationSum float64
+	// Buckets holds the number of requests in each duration bucket, these are not cumulative.
+	Buckets []uint64
+}
+
+// metrics collects the request metrics and writes them along with the state of the content in the Prometheus text
+// exposition format.
+type metrics struct {
+	content *contentStore
+
+	lock        sync.Mutex
+	requests    map[requestLabels]*requestStats
+	conditional map[string]uint64
+	// refused counts the requests refused by the ip filter by reason.
+	refused map[string]uint64
+}
+
+func newMetrics(content *contentStore) *metrics {
+	return &metrics{
+		content:     content,
+		requests:    make(map[requestLabels]*requestStats),
+		conditional: map[string]uint64{"hit": 0, "miss": 0},
+		refused:     map[string]uint64{ipDenied: 0, ipNotAllowed: 0},
+	}
+}
+
+// Refuse records a request refused by the ip filter for the given reason.
+func (m *metrics) Refuse(reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.refused[reason]++
+}
+
+// Observe records a completed request, unusual methods are recorded as 'other'. Conditional requests are the ones with
+// an If-None-Match header, they are a hit when answered with 304 Not Modified and a miss when the full response was sent.
+func (m *metrics) Observe(labels requestLabels, bytes int64, duration time.Duration, conditional bool) {
+	switch labels.Method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS", "PATCH":
+	default:
+		labels.Method = "other"
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stats, ok := m.requests[labels]
+	if !ok {
+		stats = &requestStats{Buckets: make([]uint64, len(durationBuckets))}
+		m.requests[labels] = stats
+	}
+	stats.Count++
+	stats.Bytes += uint64(bytes)
+	stats.DurationSum += duration.Seconds()
+	if i := sort.SearchFloat64s(durationBuckets, duration.Seconds()); i < len(durationBuckets) {
+		stats.Buckets[i]++
+	}
+	if conditional {
+		switch labels.Status {
+		case "304":
+			m.conditional["hit"]++
+		case "200":
+			m.conditional["miss"]++
+		}
+	}
+}
+
+// WriteTo writes all the metrics in the Prometheus text exposition format.
+func (m *metrics) WriteTo(w io.Writer) (int64, error) {
+	b := new(strings.Builder)
+	m.lock.Lock()
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l := range m.requests {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		} else if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Status < b.Status
+	})
+
+	writeHeader(b, "md_http_requests_total", "counter", "The number of http requests by route, method, and status.")
+	for _, l := range labels {
+		_, _ = fmt.Fprintf(b, "md_http_requests_total{%s} %d\n", l, m.requests[l].Count)
+	}
+	writeHeader(b, "md_http_response_bytes_total", "counter", "The number of body bytes written in http responses.")
+	for _, l := range labels {
+		_, _ = fmt.Fprintf(b, "md_http_response_bytes_total{%s} %d\n", l, m.requests[l].Bytes)
+	}
+	writeHeader(b, "md_http_request_duration_seconds", "histogram", "The time taken to serve http requests.")
+	for _, l := range labels {
+		stats := m.requests[l]
+		var cumulative uint64
+		for i, le := range durationBuckets {
+			cumulative += stats.Buckets[i]
+			_, _ = fmt.Fprintf(b, "md_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", l, formatFloat(le), cumulative)
+		}
+		_, _ = fmt.Fprintf(b, "md_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, stats.Count)
+		_, _ = fmt.Fprintf(b, "md_http_request_duration_seconds_sum{%s} %s\n", l, formatFloat(stats.DurationSum))
+		_, _ = fmt.Fprintf(b, "md_http_request_duration_seconds_count{%s} %d\n", l, stats.Count)
+	}
+	writeHeader(b, "md_http_conditional_requests_total", "counter", "The number of requests with If-None-Match, a hit is answered with 304 Not Modified and a miss with 200 OK.")
+	for _, result := range []string{"hit", "miss"} {
+		_, _ = fmt.Fprintf(b, "md_http_conditional_requests_total{result=\"%s\"} %d\n", result, m.conditional[result])
+	}
+	writeHeader(b, "md_http_ip_refused_requests_total", "counter", "The number of requests refused by the ip filter, because the client is in the deny-list or not in the allow-list.")
+	for _, reason := range []string{ipDenied, ipNotAllowed} {
+		_, _ = fmt.Fprintf(b, "md_http_ip_refused_requests_total{reason=\"%s\"} %d\n", reason, m.refused[reason])
+	}
+	m.lock.Unlock()
+
+	writeHeader(b, "md_http_content_loads_total", "counter", "The number of attempts to load the content, including the initial load.")
+	_, _ = fmt.Fprintf(b, "md_http_content_loads_total %d\n", m.content.loads.Load())
+	writeHeader(b, "md_http_content_load_failures_total", "counter", "The number of attempts to load the content that failed.")
+	_, _ = fmt.Fprintf(b, "md_http_content_load_failures_total %d\n", m.content.loadFailures.Load())
+	if snap := m.content.Snapshot(); snap != nil {
+		writeHeader(b, "md_http_content_info", "gauge", "The content being served, identified by its hash.")
+		_, _ = fmt.Fprintf(b, "md_http_content_info{hash=\"%s\",pages=\"%d\"} 1\n", snap.Hash, len(snap.Pages))
+		writeHeader(b, "md_http_content_loaded_timestamp_seconds", "gauge", "The time at which the content being served was loaded.")
+		_, _ = fmt.Fprintf(b, "md_http_content_loaded_timestamp_seconds %s\n", formatFloat(float64(snap.LoadedAt.UnixMilli())/1000))
+	}
+
+	version, revision := buildVersion()
+	writeHeader(b, "md_http_build_info", "gauge", "The version of md-http and the go version it was built with.")
+	_, _ = fmt.Fprintf(b, "md_http_build_info{version=\"%s\",revision=\"%s\",goversion=\"%s\"} 1\n", escapeLabel(version), escapeLabel(revision), runtime.Version())
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (l requestLabels) String() string {
+	return fmt.Sprintf("route=\"%s\",method=\"%s\",status=\"%s\"", escapeLabel(l.Route), escapeLabel(l.Method), l.Status)
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	_, _ = fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabel escapes a label value for the text exposition format.
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// buildVersion returns the module version and vcs revision embedded by the go toolchain.
+func buildVersion() (version, revision string) {
+	version, revision = "unknown", "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	return version, revision
+}
+
+// metricsRoute returns the route label of a request. This is the pattern of the handler that serves it, or the route
+// of the page for the pages, so that unknown paths cannot create new series.
+func metricsRoute(mux *http.ServeMux, content *contentStore, request *http.Request) string {
+	_, pattern := mux.Handler(request)
+	if pattern == "/" {
+		if snap := content.Snapshot(); snap != nil {
+			if _, ok := snap.Pages[request.URL.Path]; ok {
+				return request.URL.Path
+			}
+		}
+	} else if pattern == "" {
+		return "other"
+	}
+	return pattern
+}
+
+// metricsHandler serves the metrics in the Prometheus text exposition format.
+func metricsHandler(m *metrics) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != "GET" {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := m.WriteTo(writer); err != nil {
+			slog.Debug("failed to write metrics", "err", err)
+		}
+	}
+}
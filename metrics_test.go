@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_writeTo(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	content := &contentStore{MarkdownPath: mdPath}
+	require.NoError(t, content.Load())
+
+	m := newMetrics(content)
+	m.Observe(requestLabels{Route: "/", Method: "GET", Status: "200"}, 100, time.Millisecond*20, true)
+	m.Observe(requestLabels{Route: "/", Method: "GET", Status: "304"}, 0, time.Millisecond, true)
+	m.Observe(requestLabels{Route: "/", Method: "GET", Status: "200"}, 50, time.Second*20, false)
+	m.Observe(requestLabels{Route: "/", Method: "BREW", Status: "405"}, 0, time.Millisecond, false)
+
+	b := new(strings.Builder)
+	_, err := m.WriteTo(b)
+	require.NoError(t, err)
+	out := b.String()
+	for _, line := range []string{
+		`# TYPE md_http_requests_total counter`,
+		`md_http_requests_total{route="/",method="GET",status="200"} 2`,
+		`md_http_requests_total{route="/",method="GET",status="304"} 1`,
+		`md_http_requests_total{route="/",method="other",status="405"} 1`,
+		`md_http_response_bytes_total{route="/",method="GET",status="200"} 150`,
+		`# TYPE md_http_request_duration_seconds histogram`,
+		`md_http_request_duration_seconds_bucket{route="/",method="GET",status="200",le="0.01"} 0`,
+		`md_http_request_duration_seconds_bucket{route="/",method="GET",status="200",le="0.025"} 1`,
+		`md_http_request_duration_seconds_bucket{route="/",method="GET",status="200",le="10"} 1`,
+		`md_http_request_duration_seconds_bucket{route="/",method="GET",status="200",le="+Inf"} 2`,
+		`md_http_request_duration_seconds_sum{route="/",method="GET",status="200"} 20.02`,
+		`md_http_request_duration_seconds_count{route="/",method="GET",status="200"} 2`,
+		`md_http_conditional_requests_total{result="hit"} 1`,
+		`md_http_conditional_requests_total{result="miss"} 1`,
+		`md_http_content_loads_total 1`,
+		`md_http_content_load_failures_total 0`,
+		fmt.Sprintf(`md_http_content_info{hash="%s",pages="1"} 1`, content.Snapshot().Hash),
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+	assert.Regexp(t, `(?m)^md_http_build_info\{version=".+",revision=".+",goversion="go.+"\} 1$`, out)
+
+	require.NoError(t, os.Remove(mdPath))
+	require.Error(t, content.Load())
+	b.Reset()
+	_, err = m.WriteTo(b)
+	require.NoError(t, err)
+	assert.Contains(t, b.String(), "md_http_content_loads_total 2\n")
+	assert.Contains(t, b.String(), "md_http_content_load_failures_total 1\n")
+}
+
+func TestEscapeLabel(t *testing.T) {
+	assert.Equal(t, `a\"b\\c\nd`, escapeLabel("a\"b\\c\nd"))
+}
+
+func TestRunMetrics(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("# index\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "docs", "guide.md"), []byte("# guide\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: dir}, nil)
+
+	resp, _ := getBody(t, baseUrl+"/docs/guide")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	req, err := http.NewRequest("GET", baseUrl+"/docs/guide", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", resp.Header.Get("Etag"))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	resp, _ = getBody(t, baseUrl+"/does/not/exist")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body := getBody(t, baseUrl+"/metrics")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, `md_http_requests_total{route="/docs/guide",method="GET",status="200"} 1`+"\n")
+	assert.Contains(t, body, `md_http_requests_total{route="/docs/guide",method="GET",status="304"} 1`+"\n")
+	assert.Contains(t, body, `md_http_requests_total{route="/",method="GET",status="404"} 1`+"\n")
+	assert.Contains(t, body, `md_http_requests_total{route="/healthz",method="GET",status="200"}`)
+	assert.Contains(t, body, `md_http_conditional_requests_total{result="hit"} 1`+"\n")
+	assert.NotContains(t, body, "/does/not/exist")
+}
+
+func TestRunMetrics_separateListener(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	port, err := freePort()
+	require.NoError(t, err)
+	metricsAddrPort, err := netip.ParseAddrPort(fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, MetricsAddrPort: metricsAddrPort}, nil)
+
+	resp, _ := getBody(t, baseUrl+"/metrics")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "the page is served as the fallback rather than the metrics")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + metricsAddrPort.String() + "/metrics")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, time.Second*5, time.Millisecond*20)
+	_, body := getBody(t, "http://"+metricsAddrPort.String()+"/metrics")
+	assert.Contains(t, body, `md_http_requests_total{route="/",method="GET",status="200"} 1`+"\n")
+	assert.NotContains(t, body, `route="/metrics"`)
+}
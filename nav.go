@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// navNode is an entry in the navigation tree of a site. Directories without an index page have no route.
+type navNode struct {
+	Label    string
+	Route    string
+	Children []*navNode
+}
+
+// navItem is a navNode as seen from a particular page, with links relative to that page.
+type navItem struct {
+	Label    string
+	Href     string
+	Current  bool
+	Children []navItem
+}
+
+// buildNav builds the navigation tree from the page labels by route, following the directory hierarchy.
+func buildNav(labels map[string]string) *navNode {
+	root := &navNode{Label: "Home"}
+	if label, ok := labels["/"]; ok {
+		root.Label, root.Route = label, "/"
+	}
+	dirs := map[string]*navNode{"/": root}
+
+	var ensureDir func(dir string) *navNode
+	ensureDir = func(dir string) *navNode {
+		if node, ok := dirs[dir]; ok {
+			return node
+		}
+		node := &navNode{Label: path.Base(dir)}
+		if label, ok := labels[dir]; ok {
+			node.Label, node.Route = label, dir
+		}
+		dirs[dir] = node
+		parent := ensureDir(parentDir(dir))
+		parent.Children = append(parent.Children, node)
+		return node
+	}
+
+	routes := make([]string, 0, len(labels))
+	for route := range labels {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		if strings.HasSuffix(route, "/") {
+			ensureDir(route)
+		} else {
+			parent := ensureDir(parentDir(route))
+			parent.Children = append(parent.Children, &navNode{Label: labels[route], Route: route})
+		}
+	}
+	return root
+}
+
+// parentDir returns the directory route containing the given route, for example '/foo/' for both '/foo/bar' and
+// '/foo/bar/'.
+func parentDir(route string) string {
+	route = strings.TrimSuffix(route, "/")
+	return route[:strings.LastIndex(route, "/")+1]
+}
+
+// ForPage converts the tree into items with links relative to the given page.
+func (n *navNode) ForPage(route string) navItem {
+	item := navItem{Label: n.Label, Current: n.Route != "" && n.Route == route}
+	if n.Route != "" {
+		item.Href = relativeLink(route, n.Route)
+	}
+	for _, child := range n.Children {
+		item.Children = append(item.Children, child.ForPage(route))
+	}
+	return item
+}
+
+// Breadcrumbs returns the chain of items from the root of the tree down to the given page.
+func (n *navNode) Breadcrumbs(route string) []navItem {
+	var chain []*navNode
+	var find func(node *navNode) bool
+	find = func(node *navNode) bool {
+		chain = append(chain, node)
+		if node.Route == route {
+			return true
+		}
+		for _, child := range node.Children {
+			if find(child) {
+				return true
+			}
+		}
+		chain = chain[:len(chain)-1]
+		return false
+	}
+	if !find(n) {
+		return nil
+	}
+	items := make([]navItem, len(chain))
+	for i, node := range chain {
+		items[i] = navItem{Label: node.Label, Current: node.Route == route}
+		if node.Route != "" {
+			items[i].Href = relativeLink(route, node.Route)
+		}
+	}
+	return items
+}
+
+// relativeLink returns the relative link from one route to another.
+func relativeLink(from, to string) string {
+	link := relativeRoot(from) + strings.TrimPrefix(to, "/")
+	if !strings.HasPrefix(link, "../") {
+		link = "./" + link
+	}
+	return link
+}
+
+var atxHeadingPattern = regexp.MustCompile(`^#[ \t]+(.+?)(?:[ \t]+#+)?[ \t]*$`)
+var setextHeadingPattern = regexp.MustCompile(`^=+[ \t]*$`)
+
+// firstHeading returns the text of the first level one heading in the markdown document, or an empty string if there
+// is none. Headings inside fenced code blocks are ignored.
+func firstHeading(raw []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	var previous string
+	inFence := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		} else if !inFence {
+			if m := atxHeadingPattern.FindStringSubmatch(line); m != nil {
+				return m[1]
+			} else if setextHeadingPattern.MatchString(line) && strings.TrimSpace(previous) != "" {
+				return strings.TrimSpace(previous)
+			}
+		}
+		previous = line
+	}
+	return ""
+}
+
+// fallbackLabel is the navigation label used for a page without a heading.
+func fallbackLabel(route string) string {
+	if route == "/" {
+		return "Home"
+	}
+	return path.Base(route)
+}
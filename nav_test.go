@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirstHeading(t *testing.T) {
+	for raw, expected := range map[string]string{
+		"# Simple\n":                             "Simple",
+		"# Closed #\n":                           "Closed",
+		"intro\n\n## Second\n\n# First\n":        "First",
+		"Setext\n===\n":                          "Setext",
+		"```\n# not a heading\n```\n\n# Real\n":  "Real",
+		"no heading at all\n":                    "",
+		"#NoSpace\n":                             "",
+		"\n===\n":                                "",
+		"~~~md\n# fenced\n~~~\nAfter\n======\n":  "After",
+		"# With `code` and *emphasis*\n\nbody\n": "With `code` and *emphasis*",
+	} {
+		assert.Equal(t, expected, firstHeading([]byte(raw)), raw)
+	}
+}
+
+func TestBuildNav(t *testing.T) {
+	nav := buildNav(map[string]string{
+		"/":              "Welcome",
+		"/about":         "About",
+		"/guides/setup":  "Setup",
+		"/guides/deep/x": "X",
+		"/team/":         "Team",
+		"/team/alice":    "Alice",
+	})
+	assert.Equal(t, &navNode{Label: "Welcome", Route: "/", Children: []*navNode{
+		{Label: "About", Route: "/about"},
+		{Label: "guides", Children: []*navNode{
+			{Label: "deep", Children: []*navNode{{Label: "X", Route: "/guides/deep/x"}}},
+			{Label: "Setup", Route: "/guides/setup"},
+		}},
+		{Label: "Team", Route: "/team/", Children: []*navNode{{Label: "Alice", Route: "/team/alice"}}},
+	}}, nav)
+
+	assert.Equal(t, []navItem{
+		{Label: "Welcome", Href: "../"},
+		{Label: "Team", Href: "../team/"},
+		{Label: "Alice", Href: "../team/alice", Current: true},
+	}, nav.Breadcrumbs("/team/alice"))
+	assert.Equal(t, []navItem{
+		{Label: "Welcome", Href: "../../"},
+		{Label: "guides"},
+		{Label: "deep"},
+		{Label: "X", Href: "../../guides/deep/x", Current: true},
+	}, nav.Breadcrumbs("/guides/deep/x"))
+	assert.Nil(t, nav.Breadcrumbs("/missing"))
+
+	item := nav.ForPage("/about")
+	assert.Equal(t, "./", item.Href)
+	assert.Equal(t, navItem{Label: "About", Href: "./about", Current: true}, item.Children[0])
+}
+
+func TestBuildNav_noRootIndex(t *testing.T) {
+	nav := buildNav(map[string]string{"/a": "A"})
+	assert.Equal(t, &navNode{Label: "Home", Children: []*navNode{{Label: "A", Route: "/a"}}}, nav)
+	assert.Equal(t, []navItem{{Label: "Home"}, {Label: "A", Href: "./a", Current: true}}, nav.Breadcrumbs("/a"))
+}
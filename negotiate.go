@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// representation is one of the forms a page can be served in.
+type representation struct {
+	ContentType string
+	Body        []byte
+	Hash        string
+	// compressed is set when the body may be served compressed, see compressible.
+	compressed *compressedBodies
+}
+
+// newRepresentation builds a representation whose hash covers the content type as well as the body, so that variants
+// with the same bytes, such as markdown served as text/markdown or text/plain, still have distinct etags.
+func newRepresentation(contentType string, body []byte) representation {
+	h := sha256.New()
+	_, _ = h.Write([]byte(contentType + "\n"))
+	_, _ = h.Write(body)
+	return representation{ContentType: contentType, Body: body, Hash: fmt.Sprintf("%x", h.Sum(nil))}
+}
+
+// variantOrder is the media types a page is available in, in order of preference when the client has no preference.
+var variantOrder = []string{"text/html", "text/markdown", "text/plain"}
+
+// terminalVariantOrder is the preference order for command line http clients, which get the terminal rendering.
+var terminalVariantOrder = []string{"text/plain", "text/markdown", "text/html"}
+
+// ansiVariant is the key of the terminal rendering with ANSI colours. It is served as text/plain when asked for with
+// the 'color' query parameter.
+const ansiVariant = "text/plain+ansi"
+
+// formatMediaTypes maps the values of the 'format' query parameter to the variant they select.
+var formatMediaTypes = map[string]string{
+	"html": "text/html",
+	"md":   "text/markdown",
+	"txt":  "text/plain",
+}
+
+// negotiateContentType returns the offered media type that the Accept header rates highest, preferring earlier offers
+// when they are rated the same. An empty header accepts anything. An empty string is returned when nothing offered is
+// acceptable.
+func negotiateContentType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// the most specific range that matches the offer decides its quality
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.mediaType == offer:
+				s = 2
+			case strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(r.mediaType, "*")):
+				s = 1
+			case r.mediaType == "*/*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
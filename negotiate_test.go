@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":                                      "text/html",
+		"*/*":                                   "text/html",
+		"text/markdown":                         "text/markdown",
+		"text/plain":                            "text/plain",
+		"text/*":                                "text/html",
+		"text/html;q=0.5, text/markdown":        "text/markdown",
+		"text/markdown;q=0.5, text/plain;q=0.5": "text/markdown",
+		"text/*;q=0.5, text/plain":              "text/plain",
+		"*/*;q=0.1, text/html;q=0":              "text/markdown",
+		"application/json":                      "",
+		"text/html;q=bogus, text/plain":         "text/plain",
+		"text/html, application/xhtml+xml, application/xml;q=0.9, */*;q=0.8": "text/html",
+	} {
+		assert.Equal(t, expected, negotiateContentType(accept, variantOrder), accept)
+	}
+}
+
+func TestRunNegotiation(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "foo"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.md"), []byte("# index\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo", "bar.md"), []byte("---\ntitle: Bar\n---\n# bar\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: dir}, nil)
+
+	get := func(url, accept string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	resp, body := get(baseUrl+"/foo/bar", "")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Accept, User-Agent", resp.Header.Get("Vary"))
+	htmlEtag := resp.Header.Get("Etag")
+
+	resp, body = get(baseUrl+"/foo/bar", "text/markdown")
+	assert.Equal(t, "text/markdown; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "Accept, User-Agent", resp.Header.Get("Vary"))
+	assert.Equal(t, "---\ntitle: Bar\n---\n# bar\n", body)
+	markdownEtag := resp.Header.Get("Etag")
+
+	resp, body = get(baseUrl+"/foo/bar", "text/plain")
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "bar\n===\n", body)
+	plainEtag := resp.Header.Get("Etag")
+	assert.NotEqual(t, htmlEtag, markdownEtag)
+	assert.NotEqual(t, markdownEtag, plainEtag)
+
+	resp, body = get(baseUrl+"/foo/bar.md", "text/html")
+	assert.Equal(t, "text/markdown; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, markdownEtag, resp.Header.Get("Etag"))
+	assert.Empty(t, resp.Header.Get("Vary"))
+	resp, body = get(baseUrl+"/index.md", "")
+	assert.Equal(t, "# index\n", body)
+	resp, _ = get(baseUrl+"/missing.md", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = get(baseUrl+"/foo/bar?format=md", "text/html")
+	assert.Equal(t, "text/markdown; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("Vary"))
+	resp, _ = get(baseUrl+"/foo/bar?format=pdf", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// conditional requests compare against the etag of the negotiated variant
+	req, _ := http.NewRequest(http.MethodGet, baseUrl+"/foo/bar", nil)
+	req.Header.Set("Accept", "text/markdown")
+	req.Header.Set("If-None-Match", markdownEtag)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	req.Header.Set("If-None-Match", htmlEtag)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRunNegotiation_terminal(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n\nSee [the docs](https://example.com).\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath}, nil)
+
+	get := func(url, userAgent, accept string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	expected := "example\n=======\n\nSee the docs[1].\n\n[1]: https://example.com\n"
+	for _, userAgent := range []string{"curl/8.4.0", "Wget/1.21.4", "HTTPie/3.2.2"} {
+		resp, body := get(baseUrl+"/", userAgent, "*/*")
+		assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"), userAgent)
+		assert.Equal(t, expected, body, userAgent)
+	}
+	resp, body := get(baseUrl+"/", "Mozilla/5.0", "text/plain")
+	assert.Equal(t, expected, body)
+	plainEtag := resp.Header.Get("Etag")
+
+	// command line clients still get html when they ask for it
+	resp, _ = get(baseUrl+"/", "curl/8.4.0", "text/html")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	resp, body = get(baseUrl+"/?color=1", "curl/8.4.0", "*/*")
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, "\x1b[1;36mexample\x1b[0m\n")
+	assert.NotEqual(t, plainEtag, resp.Header.Get("Etag"))
+}
+
+func TestRunNegotiation_singleFile(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath}, nil)
+
+	resp, body := getBody(t, baseUrl+"/index.md")
+	assert.Equal(t, "text/markdown; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "# example\n", body)
+	resp, _ = getBody(t, baseUrl+"/other.md")
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// OidcPath is the prefix of the login routes. Only the callback and logout never require authentication.
+	OidcPath         = "/_auth/"
+	OidcCallbackPath = OidcPath + "callback"
+	OidcLogoutPath   = OidcPath + "logout"
+	// oidcSessionDuration is how long a login lasts, independent of the lifetime of the id token.
+	oidcSessionDuration = time.Hour * 12
+	// oidcLoginDuration is how long the user has to complete the login at the issuer.
+	oidcLoginDuration = time.Minute * 10
+	oidcSessionCookie = "md_http_session"
+	oidcLoginCookie   = "md_http_login"
+	oidcScopes        = "openid email profile"
+)
+
+// oidcLogin logs browsers in through the authorization code flow with PKCE of an OpenID Connect issuer, and keeps the
+// resulting identity in an encrypted session cookie.
+type oidcLogin struct {
+	// AllowedDomains and AllowedGroups restrict the users that may log in, by the domain of their verified email and by
+	// their groups claim. Either one matching is enough, and no restrictions allows everyone the issuer authenticates.
+	AllowedDomains []string
+	AllowedGroups  []string
+
+	config      oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	endSession  string
+	aead        cipher.AEAD
+	secure      bool
+	callbackUrl *url.URL
+}
+
+// oidcSession is the content of the session cookie.
+type oidcSession struct {
+	Name    string    `json:"name"`
+	Email   string    `json:"email,omitempty"`
+	Groups  []string  `json:"groups,omitempty"`
+	Expires time.Time `json:"exp"`
+}
+
+// oidcPending is the content of the cookie that ties the callback to the login it started.
+type oidcPending struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Return   string    `json:"return"`
+	Expires  time.Time `json:"exp"`
+}
+
+// newOidcLogin discovers the issuer and returns the login. The redirect url is the external url of OidcCallbackPath.
+// The cookie secret is used to encrypt the cookies so that sessions survive restarts, when it is empty a random one is
+// used instead.
+func newOidcLogin(ctx context.Context, issuer, clientId, clientSecret, redirectUrl, cookieSecret string) (*oidcLogin, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover the oidc issuer: %w", err)
+	}
+	var metadata struct {
+		EndSession string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to discover the oidc issuer: %w", err)
+	}
+	callbackUrl, err := url.Parse(redirectUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid oidc redirect url: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if cookieSecret == "" {
+		slog.Warn("No oidc cookie secret is set, sessions will not survive a restart")
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	} else {
+		sum := sha256.Sum256([]byte(cookieSecret))
+		key = sum[:]
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcLogin{
+		config: oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  redirectUrl,
+			Scopes:       strings.Fields(oidcScopes),
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: clientId}),
+		endSession:  metadata.EndSession,
+		aead:        aead,
+		secure:      callbackUrl.Scheme == "https",
+		callbackUrl: callbackUrl,
+	}, nil
+}
+
+// seal encrypts and authenticates the value as a cookie value. The cookie name is bound to it so that the value of one
+// cookie is never accepted as another.
+func (o *oidcLogin) seal(name string, value any) (string, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, o.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(o.aead.Seal(nonce, nonce, raw, []byte(name))), nil
+}
+
+// open reads a cookie value written by seal.
+func (o *oidcLogin) open(request *http.Request, name string, value any) error {
+	cookie, err := request.Cookie(name)
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(sealed) < o.aead.NonceSize() {
+		return errors.New("malformed cookie")
+	}
+	raw, err := o.aead.Open(nil, sealed[:o.aead.NonceSize()], sealed[o.aead.NonceSize():], []byte(name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, value)
+}
+
+func (o *oidcLogin) setCookie(writer http.ResponseWriter, name, value string, expires time.Time) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		Secure:   o.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (o *oidcLogin) clearCookie(writer http.ResponseWriter, name string) {
+	http.SetCookie(writer, &http.Cookie{Name: name, Path: "/", MaxAge: -1, Secure: o.secure, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+}
+
+// Identify returns the identity of the session cookie of the request, or nil when there is no valid session.
+func (o *oidcLogin) Identify(request *http.Request) *identity {
+	var session oidcSession
+	if err := o.open(request, oidcSessionCookie, &session); err != nil || time.Now().After(session.Expires) {
+		return nil
+	}
+	return &identity{Name: session.Name, Email: session.Email, Groups: session.Groups, Method: "oidc"}
+}
+
+// Login redirects the browser to the issuer, returning to the current url once logged in. Other requests, which can't
+// follow the login, are refused with a 401.
+func (o *oidcLogin) Login(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		http.Error(writer, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	pending := oidcPending{
+		State:    oauth2.GenerateVerifier(),
+		Nonce:    oauth2.GenerateVerifier(),
+		Verifier: oauth2.GenerateVerifier(),
+		Return:   request.URL.RequestURI(),
+		Expires:  time.Now().Add(oidcLoginDuration),
+	}
+	value, err := o.seal(oidcLoginCookie, pending)
+	if err != nil {
+		http.Error(writer, "failed to start the login", http.StatusInternalServerError)
+		return
+	}
+	o.setCookie(writer, oidcLoginCookie, value, pending.Expires)
+	http.Redirect(writer, request, o.config.AuthCodeURL(pending.State, oidc.Nonce(pending.Nonce), oauth2.S256ChallengeOption(pending.Verifier)), http.StatusFound)
+}
+
+// allowed returns whether the claims pass the domain and group restrictions.
+func (o *oidcLogin) allowed(email string, emailVerified bool, groups []string) bool {
+	if len(o.AllowedDomains) == 0 && len(o.AllowedGroups) == 0 {
+		return true
+	}
+	if _, domain, ok := strings.Cut(email, "@"); ok && emailVerified {
+		for _, d := range o.AllowedDomains {
+			if strings.EqualFold(domain, d) {
+				return true
+			}
+		}
+	}
+	for _, g := range groups {
+		if slices.Contains(o.AllowedGroups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// CallbackHandler completes the login, exchanging the code for an id token and starting a session.
+func (o *oidcLogin) CallbackHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var pending oidcPending
+		if err := o.open(request, oidcLoginCookie, &pending); err != nil || time.Now().After(pending.Expires) {
+			http.Error(writer, "the login has expired, please try again", http.StatusBadRequest)
+			return
+		}
+		o.clearCookie(writer, oidcLoginCookie)
+		query := request.URL.Query()
+		if e := query.Get("error"); e != "" {
+			slog.Warn("oidc login failed", "error", e, "description", query.Get("error_description"))
+			http.Error(writer, "the login failed: "+e, http.StatusForbidden)
+			return
+		}
+		if query.Get("state") != pending.State {
+			http.Error(writer, "the login state does not match", http.StatusBadRequest)
+			return
+		}
+
+		token, err := o.config.Exchange(request.Context(), query.Get("code"), oauth2.VerifierOption(pending.Verifier))
+		if err != nil {
+			slog.Warn("oidc code exchange failed", "err", err)
+			http.Error(writer, "the login failed", http.StatusBadGateway)
+			return
+		}
+		rawIdToken, _ := token.Extra("id_token").(string)
+		idToken, err := o.verifier.Verify(request.Context(), rawIdToken)
+		if err != nil || idToken.Nonce != pending.Nonce {
+			slog.Warn("oidc id token is invalid", "err", err)
+			http.Error(writer, "the login failed", http.StatusBadGateway)
+			return
+		}
+		var claims struct {
+			Email             string   `json:"email"`
+			EmailVerified     *bool    `json:"email_verified"`
+			PreferredUsername string   `json:"preferred_username"`
+			Groups            []string `json:"groups"`
+		}
+		if err := idToken.Claims(&claims); err != nil {
+			http.Error(writer, "the login failed", http.StatusBadGateway)
+			return
+		}
+		emailVerified := claims.EmailVerified != nil && *claims.EmailVerified
+		if !o.allowed(claims.Email, emailVerified, claims.Groups) {
+			slog.Warn("oidc user is not allowed", "subject", idToken.Subject, "email", claims.Email, "groups", claims.Groups)
+			http.Error(writer, "you are not allowed to view this page", http.StatusForbidden)
+			return
+		}
+
+		session := oidcSession{Name: idToken.Subject, Email: claims.Email, Groups: claims.Groups, Expires: time.Now().Add(oidcSessionDuration)}
+		if claims.PreferredUsername != "" {
+			session.Name = claims.PreferredUsername
+		} else if claims.Email != "" {
+			session.Name = claims.Email
+		}
+		value, err := o.seal(oidcSessionCookie, session)
+		if err != nil {
+			http.Error(writer, "the login failed", http.StatusInternalServerError)
+			return
+		}
+		o.setCookie(writer, oidcSessionCookie, value, session.Expires)
+		slog.Info("oidc login", "user", session.Name, "email", session.Email)
+		// only return to local paths so that the login can't be used as an open redirect
+		if !strings.HasPrefix(pending.Return, "/") || strings.HasPrefix(pending.Return, "//") {
+			pending.Return = "/"
+		}
+		http.Redirect(writer, request, pending.Return, http.StatusFound)
+	}
+}
+
+// LogoutHandler ends the session, and the session at the issuer when it supports rp-initiated logout.
+func (o *oidcLogin) LogoutHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		o.clearCookie(writer, oidcSessionCookie)
+		if o.endSession == "" {
+			writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = writer.Write([]byte("logged out\n"))
+			return
+		}
+		target, err := url.Parse(o.endSession)
+		if err != nil {
+			http.Error(writer, "invalid end session endpoint", http.StatusBadGateway)
+			return
+		}
+		query := target.Query()
+		query.Set("client_id", o.config.ClientID)
+		query.Set("post_logout_redirect_uri", o.callbackUrl.ResolveReference(&url.URL{Path: "/"}).String())
+		target.RawQuery = query.Encode()
+		http.Redirect(writer, request, target.String(), http.StatusFound)
+	}
+}
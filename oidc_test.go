@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testIssuer is a minimal OpenID Connect issuer which logs every authorization request straight in as the current
+// claims.
+type testIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	lock   sync.Mutex
+	claims map[string]any
+	codes  map[string]url.Values
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer := &testIssuer{key: key, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(map[string]any{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/jwks",
+			"end_session_endpoint":                  issuer.URL + "/logout",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewEncoder(writer).Encode(map[string]any{"keys": []any{map[string]string{
+			"kty": "RSA", "kid": "test", "alg": "RS256", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+			http.Error(writer, "pkce is required", http.StatusBadRequest)
+			return
+		}
+		code := oauthRandom()
+		issuer.lock.Lock()
+		issuer.codes[code] = query
+		issuer.lock.Unlock()
+		http.Redirect(writer, request, query.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(writer http.ResponseWriter, request *http.Request) {
+		if id, secret, _ := request.BasicAuth(); id != "md-http" || secret != "client-secret" {
+			http.Error(writer, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		issuer.lock.Lock()
+		authorization, ok := issuer.codes[request.PostFormValue("code")]
+		delete(issuer.codes, request.PostFormValue("code"))
+		claims := map[string]any{}
+		for k, v := range issuer.claims {
+			claims[k] = v
+		}
+		issuer.lock.Unlock()
+		challenge := sha256.Sum256([]byte(request.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(challenge[:]) != authorization.Get("code_challenge") {
+			http.Error(writer, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims["iss"], claims["aud"], claims["nonce"] = issuer.URL, authorization.Get("client_id"), authorization.Get("nonce")
+		claims["iat"], claims["exp"] = time.Now().Unix(), time.Now().Add(time.Hour).Unix()
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(map[string]any{
+			"access_token": "access", "token_type": "Bearer", "expires_in": 3600, "id_token": issuer.sign(t, claims),
+		})
+	})
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func oauthRandom() string {
+	raw := make([]byte, 16)
+	_, _ = rand.Read(raw)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func (i *testIssuer) setClaims(claims map[string]any) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.claims = claims
+}
+
+// sign returns the claims as an RS256 jwt.
+func (i *testIssuer) sign(t *testing.T, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOidcLogin_allowed(t *testing.T) {
+	o := &oidcLogin{}
+	assert.True(t, o.allowed("", false, nil))
+	o.AllowedDomains, o.AllowedGroups = []string{"example.com"}, []string{"docs"}
+	assert.True(t, o.allowed("alice@Example.com", true, nil))
+	assert.False(t, o.allowed("alice@example.com", false, nil))
+	assert.False(t, o.allowed("alice@example.org", true, []string{"other"}))
+	assert.True(t, o.allowed("alice@example.org", true, []string{"other", "docs"}))
+}
+
+func TestRunOidc(t *testing.T) {
+	issuer := newTestIssuer(t)
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	logPath := filepath.Join(dir, "access.log")
+	port, err := freePort()
+	require.NoError(t, err)
+	addrPort := netip.MustParseAddrPort(fmt.Sprintf("127.0.0.1:%d", port))
+	baseUrl := startRun(t, argsStruct{
+		AddrPort:           addrPort,
+		MarkdownFile:       mdPath,
+		OidcIssuer:         issuer.URL,
+		OidcClientId:       "md-http",
+		OidcClientSecret:   "client-secret",
+		OidcRedirectUrl:    "http://" + addrPort.String() + OidcCallbackPath,
+		OidcCookieSecret:   "cookie-secret",
+		OidcAllowedDomains: []string{"example.com"},
+		AccessLogFormat:    AccessLogCommon,
+		AccessLogFile:      logPath,
+	}, nil)
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	browser := &http.Client{Jar: jar}
+	get := func(path string) (*http.Response, string) {
+		resp, err := browser.Get(baseUrl + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	// users outside the allowed domains are refused
+	issuer.setClaims(map[string]any{"sub": "1", "email": "mallory@example.org", "email_verified": true})
+	resp, _ := get("/page?x=1")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	// and so are emails in the allowed domains that the issuer doesn't claim to have verified
+	issuer.setClaims(map[string]any{"sub": "3", "email": "bob@example.com"})
+	resp, _ = get("/page?x=1")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// the login returns to the original page and the session lasts for the following requests
+	issuer.setClaims(map[string]any{"sub": "2", "email": "alice@example.com", "email_verified": true, "preferred_username": "alice"})
+	resp, body := get("/page?x=1")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, baseUrl+"/page?x=1", resp.Request.URL.String())
+	assert.Contains(t, body, `<h1 id="example">example</h1>`)
+	browser.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, _ = get("/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// anonymous clients are sent to the issuer, except for /healthz and requests that can't follow a login
+	resp, err = http.Get(baseUrl + "/healthz")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Post(baseUrl+"/", "text/plain", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// a forged session cookie is not accepted
+	forged := &http.Client{Jar: jar, CheckRedirect: browser.CheckRedirect}
+	u, _ := url.Parse(baseUrl)
+	jar.SetCookies(u, []*http.Cookie{{Name: oidcSessionCookie, Value: base64.RawURLEncoding.EncodeToString([]byte(`{"name":"eve"}`)), Path: "/"}})
+	resp, err = forged.Get(baseUrl + "/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Location"), issuer.URL+"/authorize?"))
+
+	// logging out clears the session and continues at the issuer
+	browser.CheckRedirect = nil
+	get("/page")
+	browser.CheckRedirect = forged.CheckRedirect
+	resp, _ = get(OidcLogoutPath)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Location"), issuer.URL+"/logout?"))
+	resp, _ = get("/")
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	// only the callback and logout are exempt, anything else below the login routes needs a session
+	resp, _ = get(OidcPath + "x")
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Location"), issuer.URL+"/authorize?"))
+
+	raw, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Regexp(t, `(?m)^127\.0\.0\.1 - alice \[.+\] "GET /page\?x=1 HTTP/1\.1" 200 \d+$`, string(raw))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parsePrefixes parses a comma separated list of CIDR prefixes, a plain address is a prefix containing only itself.
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(part); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, addrErr := netip.ParseAddr(part); addrErr == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			return nil, fmt.Errorf("'%s' is not a CIDR prefix or address", part)
+		}
+	}
+	return prefixes, nil
+}
+
+// containsAddr returns whether any of the prefixes contain the address. IPv4-mapped IPv6 addresses match IPv4 prefixes.
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr returns the address of the peer of the connection, or the zero address if it cannot be parsed.
+func remoteAddr(request *http.Request) netip.Addr {
+	if addrPort, err := netip.ParseAddrPort(request.RemoteAddr); err == nil {
+		return addrPort.Addr().Unmap()
+	}
+	addr, _ := netip.ParseAddr(request.RemoteAddr)
+	return addr.Unmap()
+}
+
+// clientAddr returns the address of the client that made the request. When the peer is a trusted proxy, the chain of
+// addresses in the Forwarded or X-Forwarded-For headers is walked from the nearest hop and the first address that is
+// not itself a trusted proxy is the client.
+func clientAddr(request *http.Request, trusted []netip.Prefix) netip.Addr {
+	addr := remoteAddr(request)
+	if len(trusted) == 0 || !addr.IsValid() {
+		return addr
+	}
+	chain := forwardedFor(request.Header)
+	for i := len(chain) - 1; i >= 0 && containsAddr(trusted, addr); i-- {
+		hop, ok := parseForwardedAddr(chain[i])
+		if !ok {
+			break
+		}
+		addr = hop
+	}
+	return addr
+}
+
+// clientHost is clientAddr as a string, falling back to the raw remote address when it is not an ip address, such as
+// when listening on a unix socket.
+func clientHost(request *http.Request, trusted []netip.Prefix) string {
+	if addr := clientAddr(request, trusted); addr.IsValid() {
+		return addr.String()
+	}
+	if host, _, err := net.SplitHostPort(request.RemoteAddr); err == nil {
+		return host
+	}
+	return request.RemoteAddr
+}
+
+// clientKey identifies the client for the per client limits. It is clientHost, except that IPv6 clients are identified
+// by their /64 prefix, since a single host is usually given a whole /64 and could otherwise use a new address for every
+// request.
+func clientKey(request *http.Request, trusted []netip.Prefix) string {
+	if addr := clientAddr(request, trusted).Unmap(); addr.Is6() {
+		prefix, _ := addr.Prefix(64)
+		return prefix.String()
+	}
+	return clientHost(request, trusted)
+}
+
+// forwardedFor returns the forwarded client addresses from the standard Forwarded header, or the X-Forwarded-For
+// header when there is none, ordered from the original client to the nearest proxy.
+func forwardedFor(header http.Header) []string {
+	var chain []string
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					if k, v, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(k, "for") {
+						chain = append(chain, strings.Trim(v, `"`))
+					}
+				}
+			}
+		}
+		return chain
+	}
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			chain = append(chain, strings.TrimSpace(hop))
+		}
+	}
+	return chain
+}
+
+// parseForwardedAddr parses a forwarded address which may include a port and, for IPv6, brackets.
+func parseForwardedAddr(raw string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(raw); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(raw, "["), "]"))
+	return addr.Unmap(), err == nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := parsePrefixes(" 10.0.0.0/8, 192.168.1.7,fd00::/8 ,, ::1")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("fd00::/8"),
+		netip.MustParsePrefix("::1/128"),
+	}, prefixes)
+
+	prefixes, err = parsePrefixes("10.1.2.3/8")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, prefixes)
+
+	_, err = parsePrefixes("10.0.0.0/8,example.com")
+	assert.EqualError(t, err, "'example.com' is not a CIDR prefix or address")
+}
+
+func TestClientAddr(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+	for _, tc := range []struct {
+		name     string
+		remote   string
+		headers  map[string]string
+		trusted  []netip.Prefix
+		expected string
+	}{
+		{name: "direct", remote: "203.0.113.9:1234", expected: "203.0.113.9"},
+		{name: "untrusted peer", remote: "203.0.113.9:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.1"}, trusted: trusted, expected: "203.0.113.9"},
+		{name: "no trusted proxies", remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.1"}, expected: "10.0.0.1"},
+		{name: "trusted peer", remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.1"}, trusted: trusted, expected: "198.51.100.1"},
+		{name: "chain of proxies", remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "192.0.2.66, 198.51.100.1, 10.2.3.4"}, trusted: trusted, expected: "198.51.100.1"},
+		{name: "all trusted", remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "10.2.3.4"}, trusted: trusted, expected: "10.2.3.4"},
+		{name: "garbage hop", remote: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.1, nonsense"}, trusted: trusted, expected: "10.0.0.1"},
+		{name: "forwarded", remote: "[fd00::1]:1234", headers: map[string]string{"Forwarded": `for=192.0.2.60;proto=http, for="[2001:db8::17]:4711"`}, trusted: trusted, expected: "2001:db8::17"},
+		{name: "forwarded takes precedence", remote: "10.0.0.1:1234", headers: map[string]string{"Forwarded": "for=192.0.2.60", "X-Forwarded-For": "198.51.100.1"}, trusted: trusted, expected: "192.0.2.60"},
+		{name: "mapped peer", remote: "[::ffff:10.0.0.1]:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.1:5555"}, trusted: trusted, expected: "198.51.100.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/", nil)
+			request.RemoteAddr = tc.remote
+			for k, v := range tc.headers {
+				request.Header.Set(k, v)
+			}
+			assert.Equal(t, tc.expected, clientHost(request, tc.trusted))
+		})
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for remote, expected := range map[string]string{
+		"203.0.113.9:1234":             "203.0.113.9",
+		"[::ffff:203.0.113.9]:1234":    "203.0.113.9",
+		"[2001:db8:1:2::1]:1234":       "2001:db8:1:2::/64",
+		"[2001:db8:1:2:aaaa::ffff]:80": "2001:db8:1:2::/64",
+		"[2001:db8:1:3::1]:1234":       "2001:db8:1:3::/64",
+		"[fe80::1%eth0]:1234":          "fe80::/64",
+		"@":                            "@",
+	} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = remote
+		assert.Equal(t, expected, clientKey(request, trusted), remote)
+	}
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "10.0.0.1:1234"
+	request.Header.Set("X-Forwarded-For", "2001:db8::7")
+	assert.Equal(t, "2001:db8::/64", clientKey(request, trusted))
+}
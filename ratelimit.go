@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultRateLimitBurst = 20
+
+// rateLimiter is a token bucket per client. Each bucket holds up to Burst tokens and refills at Rate tokens per second,
+// and every request takes a token.
+type rateLimiter struct {
+	Rate  float64
+	Burst int
+	// Exempt are the paths that are not limited, see pathMatches.
+	Exempt         []string
+	TrustedProxies []netip.Prefix
+
+	lock    sync.Mutex
+	buckets clientTable[*tokenBucket]
+}
+
+type tokenBucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// refill brings the bucket up to date.
+func (l *rateLimiter) refill(b *tokenBucket, now time.Time) {
+	b.Tokens = math.Min(float64(l.Burst), b.Tokens+now.Sub(b.Updated).Seconds()*l.Rate)
+	b.Updated = now
+}
+
+// Take takes a token from the bucket of the client, and returns whether there was one along with the tokens left and
+// the time until the next token when there are none left, or until the bucket is full again otherwise. When too many
+// clients are tracked, the least recently seen one is dropped, whose bucket has almost always refilled and so is no
+// different to a new one.
+func (l *rateLimiter) Take(client string, now time.Time) (bool, float64, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	b, ok := l.buckets.Get(client)
+	if !ok {
+		b = &tokenBucket{Tokens: float64(l.Burst), Updated: now}
+		l.buckets.Put(client, b)
+	}
+	l.refill(b, now)
+	if b.Tokens < 1 {
+		return false, b.Tokens, time.Duration((1 - b.Tokens) / l.Rate * float64(time.Second))
+	}
+	b.Tokens--
+	return true, b.Tokens, time.Duration((float64(l.Burst) - b.Tokens) / l.Rate * float64(time.Second))
+}
+
+// Allow takes a token for the request, keyed by the identity of the client when it is authenticated and otherwise its
+// address, see clientKey, and sets the RateLimit headers. When the client has no tokens left, it writes a 429 response
+// and returns false.
+func (l *rateLimiter) Allow(writer http.ResponseWriter, request *http.Request) bool {
+	if probe(request) || pathMatches(request.URL.Path, l.Exempt) {
+		return true
+	}
+	client := "addr:" + clientKey(request, l.TrustedProxies)
+	if id := requestIdentity(request); id != nil {
+		client = id.Method + ":" + id.Name
+	}
+	ok, remaining, wait := l.Take(client, time.Now())
+	seconds := strconv.Itoa(int(math.Ceil(wait.Seconds())))
+	writer.Header().Set("RateLimit-Limit", strconv.Itoa(l.Burst))
+	writer.Header().Set("RateLimit-Remaining", strconv.Itoa(int(remaining)))
+	writer.Header().Set("RateLimit-Reset", seconds)
+	if !ok {
+		writer.Header().Set("Retry-After", seconds)
+		http.Error(writer, "too many requests", http.StatusTooManyRequests)
+	}
+	return ok
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Take(t *testing.T) {
+	l := &rateLimiter{Rate: 2, Burst: 2}
+	now := time.Now()
+	ok, remaining, wait := l.Take("a", now)
+	assert.True(t, ok)
+	assert.Equal(t, float64(1), remaining)
+	assert.Equal(t, time.Millisecond*500, wait)
+	ok, _, _ = l.Take("a", now)
+	assert.True(t, ok)
+	ok, _, wait = l.Take("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, time.Millisecond*500, wait)
+
+	// other clients have their own buckets, and the bucket refills over time
+	ok, _, _ = l.Take("b", now)
+	assert.True(t, ok)
+	ok, _, wait = l.Take("a", now.Add(time.Millisecond*250))
+	assert.False(t, ok)
+	assert.Equal(t, time.Millisecond*250, wait)
+	ok, _, _ = l.Take("a", now.Add(time.Millisecond*500))
+	assert.True(t, ok)
+
+	// the number of tracked clients is bounded, dropping the least recently used first
+	for i := 0; i < maxTrackedClients+10; i++ {
+		l.Take(strconv.Itoa(i), now.Add(time.Second))
+	}
+	assert.Equal(t, maxTrackedClients, l.buckets.Len())
+	_, ok = l.buckets.Get("b")
+	assert.False(t, ok)
+	_, ok = l.buckets.Get(strconv.Itoa(maxTrackedClients + 9))
+	assert.True(t, ok)
+}
+
+func TestRunRateLimit(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	baseUrl := startRun(t, argsStruct{
+		MarkdownFile:    mdPath,
+		RateLimit:       0.1,
+		RateLimitBurst:  2,
+		RateLimitExempt: []string{"/public/"},
+	}, nil)
+
+	resp, _ := getBody(t, baseUrl+"/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "10", resp.Header.Get("RateLimit-Reset"))
+	resp, _ = getBody(t, baseUrl+"/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	resp, _ = getBody(t, baseUrl+"/")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 10, retryAfter, 1)
+
+	// the probes and exempt paths are never limited
+	for i := 0; i < 3; i++ {
+		resp, _ = getBody(t, baseUrl+"/healthz")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp, _ = getBody(t, baseUrl+"/readyz")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp, _ = getBody(t, baseUrl+"/public/page")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// readiness is the detailed state of the content returned by the readiness check.
+type readiness struct {
+	Ready bool `json:"ready"`
+	// Reasons explains why the content is not ready.
+	Reasons []string `json:"reasons,omitempty"`
+	// Hash identifies the content being served.
+	Hash  string `json:"hash,omitempty"`
+	Pages int    `json:"pages"`
+	// LastLoad is when the content being served was loaded, LastAttempt and LastError are about the most recent
+	// attempt to load it, which may have failed.
+	LastLoad    *time.Time    `json:"last_load,omitempty"`
+	LastAttempt *time.Time    `json:"last_attempt,omitempty"`
+	LastError   string        `json:"last_error,omitempty"`
+	Source      assetStatus   `json:"source"`
+	Assets      []assetStatus `json:"assets"`
+}
+
+// assetStatus reports whether a local file used to build the content can currently be read.
+type assetStatus struct {
+	Kind  string `json:"kind"`
+	Path  string `json:"path"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func statAsset(kind, path string) assetStatus {
+	status := assetStatus{Kind: kind, Path: path, Ok: true}
+	if _, err := os.Stat(path); err != nil {
+		status.Ok, status.Error = false, err.Error()
+	}
+	return status
+}
+
+// Readiness checks whether the content is ready to be served. It is not ready until the first successful load, or
+// while the markdown is missing or the most recent reload failed, even though the previous content is still served.
+func (s *contentStore) Readiness() readiness {
+	r := readiness{Assets: []assetStatus{}}
+	if snap := s.Snapshot(); snap != nil {
+		r.Hash, r.Pages, r.LastLoad = snap.Hash, len(snap.Pages), &snap.LoadedAt
+	} else {
+		r.Reasons = append(r.Reasons, "the content has not been loaded")
+	}
+	if last := s.LastLoad(); last != nil {
+		r.LastAttempt = &last.Time
+		if last.Err != nil {
+			r.LastError = last.Err.Error()
+			r.Reasons = append(r.Reasons, "the last load failed")
+		}
+	}
+	if r.Source = statAsset("markdown", s.MarkdownPath); !r.Source.Ok {
+		r.Reasons = append(r.Reasons, "the markdown is missing")
+	}
+	for _, asset := range []struct{ kind, path string }{{"css", s.CssPath}, {"favicon", s.FaviconPath}, {"template", s.TemplatePath}} {
+		if asset.path != "" {
+			r.Assets = append(r.Assets, statAsset(asset.kind, asset.path))
+		}
+	}
+	r.Ready = len(r.Reasons) == 0
+	return r
+}
+
+// readinessHandler serves the readiness check, with a json description of the state of the content when the
+// 'verbose' query parameter is present.
+func readinessHandler(content *contentStore) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != "GET" {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		r := content.Readiness()
+		status := http.StatusOK
+		if !r.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writer.Header().Set("Cache-Control", "no-store")
+		if request.URL.Query().Has("verbose") {
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(status)
+			encoder := json.NewEncoder(writer)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(r); err != nil {
+				slog.Error("failed to write the readiness", "err", err)
+			}
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(status)
+		if r.Ready {
+			_, _ = writer.Write([]byte("readyz check passed"))
+		} else {
+			_, _ = writer.Write([]byte("readyz check failed: " + strings.Join(r.Reasons, ", ")))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentStore_readiness(t *testing.T) {
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	content := &contentStore{MarkdownPath: mdPath, CssPath: filepath.Join(dir, "missing.css")}
+
+	r := content.Readiness()
+	assert.False(t, r.Ready)
+	assert.Equal(t, []string{"the content has not been loaded", "the markdown is missing"}, r.Reasons)
+
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	content.CssPath = ""
+	require.NoError(t, content.Load())
+	r = content.Readiness()
+	assert.True(t, r.Ready)
+	assert.Empty(t, r.Reasons)
+	assert.Equal(t, content.Snapshot().Hash, r.Hash)
+	assert.Len(t, r.Hash, 64)
+	assert.Equal(t, 1, r.Pages)
+	assert.Equal(t, assetStatus{Kind: "markdown", Path: mdPath, Ok: true}, r.Source)
+
+	// a failed reload makes the content unready, even though the previous version is still served
+	content.CssPath = filepath.Join(dir, "missing.css")
+	require.Error(t, content.Load())
+	r = content.Readiness()
+	assert.False(t, r.Ready)
+	assert.Equal(t, []string{"the last load failed"}, r.Reasons)
+	assert.Contains(t, r.LastError, "failed to read the css file")
+	assert.True(t, r.LastAttempt.After(*r.LastLoad))
+	require.Len(t, r.Assets, 1)
+	assert.False(t, r.Assets[0].Ok)
+	assert.Equal(t, "css", r.Assets[0].Kind)
+}
+
+func TestRunReadiness(t *testing.T) {
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, Watch: true, WatchInterval: time.Millisecond * 50}, nil)
+
+	resp, body := getBody(t, baseUrl+"/readyz")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "readyz check passed", body)
+
+	resp, body = getBody(t, baseUrl+"/readyz?verbose")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var r readiness
+	require.NoError(t, json.Unmarshal([]byte(body), &r))
+	assert.True(t, r.Ready)
+	assert.NotEmpty(t, r.Hash)
+
+	require.NoError(t, os.Remove(mdPath))
+	assert.Eventually(t, func() bool {
+		resp, _ := getBody(t, baseUrl+"/readyz")
+		return resp.StatusCode == http.StatusServiceUnavailable
+	}, time.Second*5, time.Millisecond*20)
+	_, body = getBody(t, baseUrl+"/readyz")
+	assert.Contains(t, body, "the markdown is missing")
+
+	// liveness is unaffected and the last good content is still served
+	resp, _ = getBody(t, baseUrl+"/healthz")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = getBody(t, baseUrl+"/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// reopenSignals is empty as there is no SIGUSR1 on this platform.
+var reopenSignals []os.Signal
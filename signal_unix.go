@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// reopenSignals ask the server to reopen its log files after they have been rotated.
+var reopenSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	templateparse "text/template/parse"
+)
+
+// templateData is the input to the page template. Links in it are relative to the page being rendered.
+type templateData struct {
+	// Title is the title of the page. When serving a directory this includes the label of the page.
+	Title string
+	// SiteTitle is the title given by the -title option.
+	SiteTitle string
+	// Route is the url path the page is served on.
+	Route string
+	// Description and Lang are optional page metadata from the front matter.
+	Description string
+	Lang        string
+	// CssUrl and FaviconUrl are the optional links to the css and favicon.
+	CssUrl     string
+	FaviconUrl string
+	// Body is the rendered markdown.
+	Body template.HTML
+	// TableOfContents is a nested list of links to the headings in the body, or empty if there are no headings.
+	TableOfContents template.HTML
+	// Nav and Breadcrumbs are the site navigation. These are only set when serving a directory.
+	Nav         *navItem
+	Breadcrumbs []navItem
+	// Meta is the front matter of the page, this allows templates to use arbitrary variables.
+	Meta map[string]any
+	// User is the authenticated client the page is served to, or nil. Pages of templates that use it are rendered for
+	// each authenticated request.
+	User *identity
+}
+
+// DefaultTemplate is the built-in page template used when the -template option is not set.
+const DefaultTemplate = `<!DOCTYPE html>
+<html{{ if .Lang }} lang="{{ .Lang }}"{{ end }}>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ .Title }}</title>
+  {{- if .Description }}
+  <meta name="description" content="{{ .Description }}">
+  {{- end }}
+  {{- if .CssUrl }}
+  <link rel="stylesheet" type="text/css" href="{{ .CssUrl }}">
+  {{- end }}
+  {{- if .FaviconUrl }}
+  <link rel="icon" href="{{ .FaviconUrl }}">
+  {{- end }}
+  {{- if .Nav }}
+  <style>
+    .md-http-layout { display: flex; gap: 2em; }
+    .md-http-sidebar { flex: 0 0 14em; }
+    .md-http-content { flex: 1 1 auto; min-width: 0; }
+    .md-http-breadcrumbs ol { list-style: none; padding: 0; }
+    .md-http-breadcrumbs li { display: inline; }
+    .md-http-breadcrumbs li + li::before { content: " / "; }
+  </style>
+  {{- end }}
+</head>
+<body>
+{{- if .Nav }}
+<nav class="md-http-breadcrumbs" aria-label="Breadcrumbs">
+  <ol>
+  {{- range .Breadcrumbs }}
+    <li>{{ template "link" . }}</li>
+  {{- end }}
+  </ol>
+</nav>
+<div class="md-http-layout">
+<nav class="md-http-sidebar" aria-label="Pages">
+  <ul>
+    <li>{{ template "link" .Nav }}{{ template "children" .Nav.Children }}</li>
+  </ul>
+</nav>
+<main class="md-http-content">
+{{ .Body }}
+</main>
+</div>
+{{- else }}
+{{ .Body }}
+{{- end }}
+</body>
+</html>
+{{- define "link" }}{{ if .Current }}<strong aria-current="page">{{ .Label }}</strong>{{ else if .Href }}<a href="{{ .Href }}">{{ .Label }}</a>{{ else }}{{ .Label }}{{ end }}{{ end }}
+{{- define "children" }}{{ if . }}<ul>{{ range . }}<li>{{ template "link" . }}{{ template "children" .Children }}</li>{{ end }}</ul>{{ end }}{{ end }}
+`
+
+// loadTemplate parses the page template from the given file, or the default template if the path is empty.
+func loadTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return template.New("default").Parse(DefaultTemplate)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the template file: %w", err)
+	}
+	tmpl, err := template.New(filepath.Base(path)).Parse(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the template file: %w", err)
+	}
+	return tmpl, nil
+}
+
+// templateUsesField returns whether any of the templates refer to the top level field, as '.Field' or '$.Field'. The
+// dot isn't followed into blocks, so a field of the same name on another value also counts, which only costs needless
+// renders, while fields reached through variables, such as '$data.Field', are not found.
+func templateUsesField(tmpl *template.Template, field string) bool {
+	var walk func(node templateparse.Node) bool
+	walk = func(node templateparse.Node) bool {
+		switch n := node.(type) {
+		case *templateparse.ListNode:
+			if n == nil {
+				return false
+			}
+			for _, child := range n.Nodes {
+				if walk(child) {
+					return true
+				}
+			}
+		case *templateparse.ActionNode:
+			return walk(n.Pipe)
+		case *templateparse.IfNode:
+			return walk(n.Pipe) || walk(n.List) || walk(n.ElseList)
+		case *templateparse.RangeNode:
+			return walk(n.Pipe) || walk(n.List) || walk(n.ElseList)
+		case *templateparse.WithNode:
+			return walk(n.Pipe) || walk(n.List) || walk(n.ElseList)
+		case *templateparse.TemplateNode:
+			return n.Pipe != nil && walk(n.Pipe)
+		case *templateparse.PipeNode:
+			if n == nil {
+				return false
+			}
+			for _, cmd := range n.Cmds {
+				if walk(cmd) {
+					return true
+				}
+			}
+		case *templateparse.CommandNode:
+			for _, arg := range n.Args {
+				if walk(arg) {
+					return true
+				}
+			}
+		case *templateparse.ChainNode:
+			return walk(n.Node)
+		case *templateparse.FieldNode:
+			return n.Ident[0] == field
+		case *templateparse.VariableNode:
+			return len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == field
+		}
+		return false
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && walk(t.Tree.Root) {
+			return true
+		}
+	}
+	return false
+}
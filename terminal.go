@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/russross/blackfriday"
+)
+
+// DefaultTerminalWidth is the column at which the terminal rendering wraps paragraphs.
+const DefaultTerminalWidth = 80
+
+// terminalClientPattern matches the User-Agent of command line http clients, which are served the terminal rendering
+// unless they ask for something else.
+var terminalClientPattern = regexp.MustCompile(`(?i)^(curl|wget|httpie|xh)/`)
+
+// isTerminalClient returns true if the User-Agent looks like a command line http client.
+func isTerminalClient(userAgent string) bool {
+	return terminalClientPattern.MatchString(userAgent)
+}
+
+// Blackfriday renders the content of a block before the block itself, so the terminal renderer cannot know the
+// indentation of a paragraph while wrapping it. Instead, the output is built with these markers and finished once the
+// whole document is known.
+const (
+	// terminalBlock starts the output of every block, containers use it to separate the blocks inside them.
+	terminalBlock = "\x1e"
+	// terminalWrap starts the text of a line that is wrapped after it has been indented.
+	terminalWrap = "\x00"
+	// terminalCell starts every table cell.
+	terminalCell = "\x1f"
+	// terminalBreak is a hard line break inside a paragraph.
+	terminalBreak = "\x01"
+)
+
+// ANSI escape codes used when colour is enabled.
+const (
+	ansiBold    = "1"
+	ansiItalic  = "3"
+	ansiStrike  = "9"
+	ansiCode    = "33"
+	ansiHeading = "1;36"
+	ansiLink    = "4;34"
+)
+
+// terminalRenderer is a blackfriday renderer which produces plain text for reading in a terminal: paragraphs are
+// wrapped, headings are underlined, links become numbered references listed at the end, and tables are aligned.
+type terminalRenderer struct {
+	width   int
+	color   bool
+	rewrite func(string) string
+
+	links     []string
+	linkIds   map[string]int
+	lists     []terminalList
+	footnotes map[string]int
+}
+
+// terminalList is the state of a list being rendered.
+type terminalList struct {
+	count int
+	loose bool
+}
+
+// renderTerminal converts the raw markdown into text for a terminal, optionally with ANSI colours. The rewriteLink
+// function is applied to the destination of every link in the document.
+func renderTerminal(raw []byte, rewriteLink func(string) string, color bool) (text []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to render markdown: %v", r)
+		}
+	}()
+	return blackfriday.Markdown(raw, &terminalRenderer{width: DefaultTerminalWidth, color: color, rewrite: rewriteLink}, markdownExtensions), nil
+}
+
+func (r *terminalRenderer) style(codes, text string) string {
+	if !r.color || text == "" {
+		return text
+	}
+	return "\x1b[" + codes + "m" + text + "\x1b[0m"
+}
+
+// capture runs the callback that renders the content of an element and returns that content rather than leaving it in
+// the output.
+func capture(out *bytes.Buffer, text func() bool) (string, bool) {
+	start := out.Len()
+	ok := text()
+	content := out.String()[start:]
+	out.Truncate(start)
+	return content, ok
+}
+
+// paragraph turns inline content into lines to be wrapped, collapsing the soft line breaks.
+func (r *terminalRenderer) paragraph(content string) string {
+	b := new(strings.Builder)
+	for _, line := range strings.Split(content, terminalBreak) {
+		b.WriteString(terminalWrap + strings.Join(strings.Fields(line), " ") + "\n")
+	}
+	return b.String()
+}
+
+// blocks converts any inline content at the start of a list item into a paragraph, because tight list items start
+// with inline content rather than a block.
+func (r *terminalRenderer) blocks(content string) string {
+	if inline, _, _ := strings.Cut(content, terminalBlock); strings.TrimSpace(inline) != "" {
+		return terminalBlock + r.paragraph(inline) + content[len(inline):]
+	}
+	return content
+}
+
+// indentBlocks joins the blocks in the content and prefixes every line, the first line with first and the others with
+// rest. Loose content has a blank line between blocks.
+func indentBlocks(content, first, rest string, loose bool) string {
+	var lines []string
+	for _, block := range strings.Split(content, terminalBlock) {
+		if strings.TrimSpace(block) == "" {
+			continue
+		}
+		if loose && len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, strings.Split(strings.TrimRight(block, "\n"), "\n")...)
+	}
+	b := new(strings.Builder)
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		b.WriteString(prefix + line + "\n")
+	}
+	return b.String()
+}
+
+func (r *terminalRenderer) BlockCode(out *bytes.Buffer, text []byte, infoString string) {
+	out.WriteString(terminalBlock)
+	for _, line := range strings.Split(strings.TrimRight(string(text), "\n"), "\n") {
+		out.WriteString("    " + r.style(ansiCode, line) + "\n")
+	}
+}
+
+func (r *terminalRenderer) BlockQuote(out *bytes.Buffer, text []byte) {
+	out.WriteString(terminalBlock + indentBlocks(string(text), "> ", "> ", true))
+}
+
+func (r *terminalRenderer) BlockHtml(out *bytes.Buffer, text []byte) {
+	out.WriteString(terminalBlock + strings.TrimRight(string(text), "\n") + "\n")
+}
+
+func (r *terminalRenderer) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	content, ok := capture(out, text)
+	if !ok {
+		return
+	}
+	title := strings.Join(strings.Fields(strings.ReplaceAll(content, terminalBreak, " ")), " ")
+	underline := "~"
+	switch level {
+	case 1:
+		underline = "="
+	case 2:
+		underline = "-"
+	}
+	length := visibleWidth(title)
+	if length > r.width {
+		length = r.width
+	}
+	out.WriteString(terminalBlock + r.style(ansiHeading, title) + "\n" + strings.Repeat(underline, length) + "\n")
+}
+
+func (r *terminalRenderer) HRule(out *bytes.Buffer) {
+	out.WriteString(terminalBlock + strings.Repeat("-", r.width) + "\n")
+}
+
+func (r *terminalRenderer) List(out *bytes.Buffer, text func() bool, flags int) {
+	r.lists = append(r.lists, terminalList{})
+	content, ok := capture(out, text)
+	list := r.lists[len(r.lists)-1]
+	r.lists = r.lists[:len(r.lists)-1]
+	if !ok {
+		return
+	}
+	out.WriteString(terminalBlock + indentBlocks(content, "", "", list.loose))
+}
+
+func (r *terminalRenderer) ListItem(out *bytes.Buffer, text []byte, flags int) {
+	list := &r.lists[len(r.lists)-1]
+	list.count++
+	if flags&blackfriday.LIST_TYPE_TERM != 0 {
+		out.WriteString(terminalBlock + r.style(ansiBold, strings.Join(strings.Fields(string(text)), " ")) + "\n")
+		return
+	}
+	if flags&blackfriday.LIST_ITEM_CONTAINS_BLOCK != 0 {
+		list.loose = true
+	}
+	marker := "- "
+	if flags&blackfriday.LIST_TYPE_DEFINITION != 0 {
+		marker = "    "
+	} else if flags&blackfriday.LIST_TYPE_ORDERED != 0 {
+		marker = strconv.Itoa(list.count) + ". "
+	}
+	out.WriteString(terminalBlock + indentBlocks(r.blocks(string(text)), marker, strings.Repeat(" ", len(marker)), flags&blackfriday.LIST_ITEM_CONTAINS_BLOCK != 0))
+}
+
+func (r *terminalRenderer) Paragraph(out *bytes.Buffer, text func() bool) {
+	content, ok := capture(out, text)
+	if !ok {
+		return
+	}
+	out.WriteString(terminalBlock + r.paragraph(content))
+}
+
+func (r *terminalRenderer) Table(out *bytes.Buffer, header []byte, body []byte, columnData []int) {
+	parse := func(rows []byte) [][]string {
+		var cells [][]string
+		for _, row := range strings.Split(strings.TrimRight(string(rows), "\n"), "\n") {
+			if row != "" {
+				cells = append(cells, strings.Split(row, terminalCell)[1:])
+			}
+		}
+		return cells
+	}
+	headerRows, bodyRows := parse(header), parse(body)
+	widths := make([]int, len(columnData))
+	for _, row := range append(headerRows[:len(headerRows):len(headerRows)], bodyRows...) {
+		for i, cell := range row {
+			if i < len(widths) && visibleWidth(cell) > widths[i] {
+				widths[i] = visibleWidth(cell)
+			}
+		}
+	}
+	writeRow := func(row []string) {
+		cells := make([]string, len(widths))
+		for i := range widths {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			padding := widths[i] - visibleWidth(cell)
+			switch columnData[i] {
+			case blackfriday.TABLE_ALIGNMENT_RIGHT:
+				cell = strings.Repeat(" ", padding) + cell
+			case blackfriday.TABLE_ALIGNMENT_CENTER:
+				cell = strings.Repeat(" ", padding/2) + cell + strings.Repeat(" ", padding-padding/2)
+			default:
+				cell += strings.Repeat(" ", padding)
+			}
+			cells[i] = cell
+		}
+		out.WriteString(strings.TrimRight(strings.Join(cells, " | "), " ") + "\n")
+	}
+
+	out.WriteString(terminalBlock)
+	for _, row := range headerRows {
+		writeRow(row)
+	}
+	if len(headerRows) > 0 {
+		rules := make([]string, len(widths))
+		for i, w := range widths {
+			rules[i] = strings.Repeat("-", w)
+		}
+		out.WriteString(strings.Join(rules, "-+-") + "\n")
+	}
+	for _, row := range bodyRows {
+		writeRow(row)
+	}
+}
+
+func (r *terminalRenderer) TableRow(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+	out.WriteString("\n")
+}
+
+func (r *terminalRenderer) TableHeaderCell(out *bytes.Buffer, text []byte, flags int) {
+	out.WriteString(terminalCell + r.style(ansiBold, strings.Join(strings.Fields(string(text)), " ")))
+}
+
+func (r *terminalRenderer) TableCell(out *bytes.Buffer, text []byte, flags int) {
+	out.WriteString(terminalCell + strings.Join(strings.Fields(string(text)), " "))
+}
+
+func (r *terminalRenderer) Footnotes(out *bytes.Buffer, text func() bool) {
+	content, ok := capture(out, text)
+	if !ok {
+		return
+	}
+	out.WriteString(terminalBlock + strings.Repeat("-", 8) + "\n" + content)
+}
+
+func (r *terminalRenderer) FootnoteItem(out *bytes.Buffer, name, text []byte, flags int) {
+	marker := fmt.Sprintf("[^%d] ", r.footnotes[string(name)])
+	out.WriteString(terminalBlock + indentBlocks(r.blocks(string(text)), marker, strings.Repeat(" ", len(marker)), true))
+}
+
+func (r *terminalRenderer) TitleBlock(out *bytes.Buffer, text []byte) {
+	out.WriteString(terminalBlock + string(text) + "\n")
+}
+
+func (r *terminalRenderer) AutoLink(out *bytes.Buffer, link []byte, kind int) {
+	out.WriteString(r.style(ansiLink, strings.TrimPrefix(string(link), "mailto:")))
+}
+
+func (r *terminalRenderer) CodeSpan(out *bytes.Buffer, text []byte) {
+	if r.color {
+		out.WriteString(r.style(ansiCode, string(text)))
+	} else {
+		out.WriteString("`" + string(text) + "`")
+	}
+}
+
+func (r *terminalRenderer) emphasis(out *bytes.Buffer, text []byte, codes, marker string) {
+	if r.color {
+		out.WriteString(r.style(codes, string(text)))
+	} else {
+		out.WriteString(marker + string(text) + marker)
+	}
+}
+
+func (r *terminalRenderer) DoubleEmphasis(out *bytes.Buffer, text []byte) {
+	r.emphasis(out, text, ansiBold, "**")
+}
+
+func (r *terminalRenderer) Emphasis(out *bytes.Buffer, text []byte) {
+	r.emphasis(out, text, ansiItalic, "*")
+}
+
+func (r *terminalRenderer) TripleEmphasis(out *bytes.Buffer, text []byte) {
+	r.emphasis(out, text, ansiBold+";"+ansiItalic, "***")
+}
+
+func (r *terminalRenderer) StrikeThrough(out *bytes.Buffer, text []byte) {
+	r.emphasis(out, text, ansiStrike, "~~")
+}
+
+// reference returns the number of the link in the list of references at the end of the document.
+func (r *terminalRenderer) reference(link string) int {
+	if r.linkIds == nil {
+		r.linkIds = make(map[string]int)
+	}
+	if id, ok := r.linkIds[link]; ok {
+		return id
+	}
+	r.links = append(r.links, link)
+	r.linkIds[link] = len(r.links)
+	return len(r.links)
+}
+
+func (r *terminalRenderer) Image(out *bytes.Buffer, link []byte, title []byte, alt []byte) {
+	label := "image"
+	if len(alt) > 0 {
+		label += ": " + string(alt)
+	}
+	out.WriteString(fmt.Sprintf("[%s][%d]", label, r.reference(r.rewrite(string(link)))))
+}
+
+func (r *terminalRenderer) LineBreak(out *bytes.Buffer) {
+	out.WriteString(terminalBreak)
+}
+
+func (r *terminalRenderer) Link(out *bytes.Buffer, link []byte, title []byte, content []byte) {
+	destination := r.rewrite(string(link))
+	text := string(content)
+	if strings.HasPrefix(destination, "#") || strings.TrimPrefix(destination, "mailto:") == text {
+		out.WriteString(r.style(ansiLink, text))
+		return
+	}
+	out.WriteString(fmt.Sprintf("%s[%d]", r.style(ansiLink, text), r.reference(destination)))
+}
+
+func (r *terminalRenderer) RawHtmlTag(out *bytes.Buffer, tag []byte) {
+	// inline html tags have no meaning in a terminal, their content is still rendered
+}
+
+func (r *terminalRenderer) FootnoteRef(out *bytes.Buffer, ref []byte, id int) {
+	if r.footnotes == nil {
+		r.footnotes = make(map[string]int)
+	}
+	r.footnotes[string(ref)] = id
+	out.WriteString(fmt.Sprintf("[^%d]", id))
+}
+
+func (r *terminalRenderer) Entity(out *bytes.Buffer, entity []byte) {
+	out.WriteString(html.UnescapeString(string(entity)))
+}
+
+func (r *terminalRenderer) NormalText(out *bytes.Buffer, text []byte) {
+	out.Write(text)
+}
+
+func (r *terminalRenderer) DocumentHeader(out *bytes.Buffer) {
+}
+
+// DocumentFooter adds the link references and then finishes the whole document, separating the blocks with blank
+// lines and wrapping the text.
+func (r *terminalRenderer) DocumentFooter(out *bytes.Buffer) {
+	if len(r.links) > 0 {
+		out.WriteString(terminalBlock)
+		for i, link := range r.links {
+			out.WriteString(fmt.Sprintf("[%d]: %s\n", i+1, link))
+		}
+	}
+	content := indentBlocks(out.String(), "", "", true)
+	out.Reset()
+	for _, line := range strings.SplitAfter(content, "\n") {
+		prefix, text, ok := strings.Cut(line, terminalWrap)
+		if !ok {
+			out.WriteString(line)
+			continue
+		}
+		for _, wrapped := range wrapText(strings.TrimSuffix(text, "\n"), r.width-visibleWidth(prefix)) {
+			out.WriteString(strings.TrimRight(prefix+wrapped, " ") + "\n")
+			// continuation lines keep the quote markers but not the list markers
+			prefix = strings.Map(func(c rune) rune {
+				if c == '>' {
+					return c
+				}
+				return ' '
+			}, prefix)
+		}
+	}
+}
+
+func (r *terminalRenderer) GetFlags() int {
+	return 0
+}
+
+// wrapText splits the text into lines of at most width visible characters, breaking between words. Words longer than
+// the width are kept whole.
+func wrapText(text string, width int) []string {
+	if width < 20 {
+		width = 20
+	}
+	var lines []string
+	line, lineWidth := "", 0
+	for _, word := range strings.Fields(text) {
+		w := visibleWidth(word)
+		if lineWidth > 0 && lineWidth+1+w > width {
+			lines = append(lines, line)
+			line, lineWidth = "", 0
+		}
+		if lineWidth > 0 {
+			line += " "
+			lineWidth++
+		}
+		line += word
+		lineWidth += w
+	}
+	return append(lines, line)
+}
+
+// visibleWidth returns the number of characters in the text, ignoring ANSI escape sequences.
+func visibleWidth(text string) int {
+	width := 0
+	for i := 0; i < len(text); {
+		if strings.HasPrefix(text[i:], "\x1b[") {
+			if end := strings.IndexByte(text[i:], 'm'); end >= 0 {
+				i += end + 1
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		width++
+	}
+	return width
+}
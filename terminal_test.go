@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTerminal(t *testing.T) {
+	raw := `# Title
+
+Some *emphasis*, **strong**, ` + "`code`" + `, a [link](http://example.com) and [another](./foo.md), then the
+[link](http://example.com) again in a paragraph which is long enough to be wrapped.
+
+## Lists
+
+- one
+- two, which is also long enough that it has to be wrapped onto a second line of text
+  - nested
+- three
+
+1. first
+2. second
+
+> quoted
+>
+> twice
+
+` + "```" + `
+code block
+` + "```" + `
+
+| Name | Value |
+|:-----|------:|
+| a | 1 |
+| longer | 200 |
+
+A note[^n] and ![a picture](pic.png).
+
+[^n]: The footnote.
+`
+	text, err := renderTerminal([]byte(raw), func(link string) string {
+		return rewriteMarkdownLink(link, "index.md", "/", map[string]string{"foo.md": "/foo"})
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, `Title
+=====
+
+Some *emphasis*, **strong**, `+"`code`"+`, a link[1] and another[2], then the link[1]
+again in a paragraph which is long enough to be wrapped.
+
+Lists
+-----
+
+- one
+- two, which is also long enough that it has to be wrapped onto a second line of
+  text
+  - nested
+- three
+
+1. first
+2. second
+
+> quoted
+>
+> twice
+
+    code block
+
+Name   | Value
+-------+------
+a      |     1
+longer |   200
+
+A note[^1] and [image: a picture][3].
+
+--------
+
+[^1] The footnote.
+
+[1]: http://example.com
+[2]: ./foo
+[3]: pic.png
+`, string(text))
+}
+
+func TestRenderTerminal_color(t *testing.T) {
+	text, err := renderTerminal([]byte("## Heading\n\nsome **bold** [link](http://example.com)\n"), func(link string) string {
+		return link
+	}, true)
+	require.NoError(t, err)
+	assert.Equal(t, "\x1b[1;36mHeading\x1b[0m\n-------\n\nsome \x1b[1mbold\x1b[0m \x1b[4;34mlink\x1b[0m[1]\n\n[1]: http://example.com\n", string(text))
+}
+
+func TestWrapText(t *testing.T) {
+	assert.Equal(t, []string{"aaaa bbbb cccc dddd", "eeee"}, wrapText("aaaa bbbb cccc dddd eeee", 20))
+	assert.Equal(t, []string{"a", "bbbbbbbbbbbbbbbbbbbbbbbbb", "c"}, wrapText("a bbbbbbbbbbbbbbbbbbbbbbbbb c", 20))
+	assert.Equal(t, []string{""}, wrapText("", 20))
+	assert.Equal(t, 4, visibleWidth("\x1b[1mbold\x1b[0m"))
+}
+
+func TestIsTerminalClient(t *testing.T) {
+	assert.True(t, isTerminalClient("curl/8.4.0"))
+	assert.True(t, isTerminalClient("Wget/1.21.4"))
+	assert.True(t, isTerminalClient("HTTPie/3.2.2"))
+	assert.False(t, isTerminalClient("Mozilla/5.0 (X11; Linux x86_64)"))
+	assert.False(t, isTerminalClient(""))
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const DefaultTlsMinVersion = "1.2"
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certificateStore holds the certificate served by the tls listener, which is swapped when the files change so that
+// rotated certificates are picked up without a restart.
+type certificateStore struct {
+	CertPath string
+	KeyPath  string
+	// ClientCaPath is an optional bundle of the certificate authorities that client certificates must be issued by.
+	ClientCaPath string
+
+	current   atomic.Pointer[tls.Certificate]
+	clientCas atomic.Pointer[x509.CertPool]
+}
+
+// Load reads the certificate and key files, and the client certificate authorities. On error the previous
+// certificates, if any, continue to be used.
+func (c *certificateStore) Load() error {
+	cert, err := tls.LoadX509KeyPair(c.CertPath, c.KeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load the tls certificate: %w", err)
+	}
+	var clientCas *x509.CertPool
+	if c.ClientCaPath != "" {
+		raw, err := os.ReadFile(c.ClientCaPath)
+		if err != nil {
+			return fmt.Errorf("failed to read the client certificate authorities: %w", err)
+		}
+		clientCas = x509.NewCertPool()
+		if !clientCas.AppendCertsFromPEM(raw) {
+			return fmt.Errorf("failed to read the client certificate authorities: no certificates found in '%s'", c.ClientCaPath)
+		}
+	}
+	c.clientCas.Store(clientCas)
+	if old := c.current.Swap(&cert); old != nil {
+		slog.Info("reloaded tls certificate", "path", c.CertPath)
+	}
+	return nil
+}
+
+// Reload is Load but logs the error rather than returning it, so that it can be used as a callback.
+func (c *certificateStore) Reload() {
+	if err := c.Load(); err != nil {
+		slog.Error("failed to reload the tls certificate, continuing to serve the previous one", "err", err)
+	}
+}
+
+// Paths returns the files to watch for changes.
+func (c *certificateStore) Paths() []string {
+	if c.ClientCaPath != "" {
+		return []string{c.CertPath, c.KeyPath, c.ClientCaPath}
+	}
+	return []string{c.CertPath, c.KeyPath}
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *certificateStore) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.current.Load(), nil
+}
+
+// parseTlsVersion parses a minimum tls version such as '1.2'.
+func parseTlsVersion(value string) (uint16, error) {
+	if v, ok := tlsVersions[value]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("must be one of %s", strings.Join(sortedKeys(tlsVersions), ", "))
+}
+
+// parseCipherSuites parses a comma separated list of cipher suite names as listed by Go, such as
+// 'TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256'. An empty list keeps the secure defaults. Suites that Go considers insecure
+// are rejected.
+func parseCipherSuites(value string) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+	insecure := make(map[string]bool)
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+	var ids []uint16
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if insecure[name] {
+			return nil, fmt.Errorf("the cipher suite '%s' is insecure", name)
+		}
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite '%s', expected one of %s", name, strings.Join(sortedKeys(byName), ", "))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// newTlsConfig returns the server tls configuration. The cipher suites only apply to tls 1.2 and earlier, the tls 1.3
+// suites are not configurable. When the store has client certificate authorities, clients must present a certificate
+// issued by one of them and, if there is an allow-list, named by it.
+func newTlsConfig(certs *certificateStore, minVersion uint16, cipherSuites []uint16, clientAllow []string) *tls.Config {
+	config := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+	}
+	if certs.ClientCaPath == "" {
+		return config
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(clientAllow) > 0 {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 || !certificateAllowed(state.PeerCertificates[0], clientAllow) {
+				return errors.New("the client certificate is not in the allow-list")
+			}
+			return nil
+		}
+	}
+	// the certificate authorities are looked up for each handshake so that reloading them takes effect
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = certs.clientCas.Load()
+		return c, nil
+	}
+	return config
+}
+
+// httpsRedirectHandler redirects every request to the same url on the https port.
+func httpsRedirectHandler(httpsPort uint16) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(int(httpsPort)))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(writer, request, "https://"+host+request.URL.RequestURI(), http.StatusPermanentRedirect)
+	}
+}
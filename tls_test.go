@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a certificate for 127.0.0.1 and its key to the paths, signed by the parent certificate
+// and key or self-signed when they are nil.
+func writeTestCertificate(t *testing.T, certPath, keyPath, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(raw)
+	require.NoError(t, err)
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}), 0600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600))
+	return cert, key
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := parseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, ids)
+
+	ids, err = parseCipherSuites("")
+	require.NoError(t, err)
+	assert.Nil(t, ids)
+
+	_, err = parseCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+	assert.EqualError(t, err, "the cipher suite 'TLS_RSA_WITH_RC4_128_SHA' is insecure")
+	_, err = parseCipherSuites("TLS_NOPE")
+	assert.ErrorContains(t, err, "unknown cipher suite 'TLS_NOPE', expected one of ")
+}
+
+func TestHttpsRedirectHandler(t *testing.T) {
+	for _, tc := range []struct {
+		host     string
+		port     uint16
+		expected string
+	}{
+		{host: "example.com", port: 443, expected: "https://example.com/a/b?c=d"},
+		{host: "example.com:80", port: 443, expected: "https://example.com/a/b?c=d"},
+		{host: "example.com:8080", port: 8443, expected: "https://example.com:8443/a/b?c=d"},
+		{host: "[::1]:8080", port: 8443, expected: "https://[::1]:8443/a/b?c=d"},
+		{host: "[::1]", port: 443, expected: "https://[::1]/a/b?c=d"},
+	} {
+		request, err := http.NewRequest("POST", "http://"+tc.host+"/a/b?c=d", nil)
+		require.NoError(t, err)
+		recorder := httptest.NewRecorder()
+		httpsRedirectHandler(tc.port)(recorder, request)
+		assert.Equal(t, http.StatusPermanentRedirect, recorder.Code, tc.host)
+		assert.Equal(t, tc.expected, recorder.Header().Get("Location"), tc.host)
+	}
+}
+
+func TestRunTls(t *testing.T) {
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first, _ := writeTestCertificate(t, certPath, keyPath, "first", nil, nil)
+
+	port, err := freePort()
+	require.NoError(t, err)
+	redirectAddrPort := netip.MustParseAddrPort(fmt.Sprintf("127.0.0.1:%d", port))
+	baseUrl := "https://" + strings.TrimPrefix(startRun(t, argsStruct{
+		MarkdownFile:        mdPath,
+		TlsCertFile:         certPath,
+		TlsKeyFile:          keyPath,
+		TlsMinVersion:       tls.VersionTLS12,
+		TlsRedirectAddrPort: redirectAddrPort,
+		WatchInterval:       time.Millisecond * 50,
+	}, nil), "http://")
+
+	// trust any certificate and handshake for every request so that the rotation can be observed through the served
+	// certificate
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, DisableKeepAlives: true},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	servedCommonName := func() string {
+		resp, err := client.Get(baseUrl + "/")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	assert.Equal(t, "first", servedCommonName())
+
+	// the certificate is verifiable by a client that trusts it
+	pool := x509.NewCertPool()
+	pool.AddCert(first)
+	verifying := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := verifying.Get(baseUrl + "/healthz")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	// clients below the minimum version are refused
+	old := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS11}}}
+	_, err = old.Get(baseUrl + "/")
+	assert.Error(t, err)
+
+	writeTestCertificate(t, certPath, keyPath, "second", nil, nil)
+	assert.Eventually(t, func() bool {
+		return servedCommonName() == "second"
+	}, time.Second*5, time.Millisecond*20)
+
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get("http://" + redirectAddrPort.String() + "/foo?bar=1")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusPermanentRedirect && resp.Header.Get("Location") == baseUrl+"/foo?bar=1"
+	}, time.Second*5, time.Millisecond*20)
+
+	output := new(bytes.Buffer)
+	require.NoError(t, mainInner([]string{"md-http", "healthcheck", "-listen", strings.TrimPrefix(baseUrl, "https://"), "-tls-cert", certPath}, output))
+	assert.Equal(t, "healthy\n", output.String())
+}
+
+func TestRunMutualTls(t *testing.T) {
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, certPath, keyPath, "server", nil, nil)
+	caPath := filepath.Join(dir, "ca.crt")
+	ca, caKey := writeTestCertificate(t, caPath, filepath.Join(dir, "ca.key"), "internal ca", nil, nil)
+	clientCert := func(name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) tls.Certificate {
+		certPath, keyPath := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+		writeTestCertificate(t, certPath, keyPath, name, parent, parentKey)
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		require.NoError(t, err)
+		return cert
+	}
+	alice, mallory, eve := clientCert("alice", ca, caKey), clientCert("mallory", ca, caKey), clientCert("eve", nil, nil)
+
+	logPath := filepath.Join(dir, "access.log")
+	baseUrl := "https://" + strings.TrimPrefix(startRun(t, argsStruct{
+		MarkdownFile:    mdPath,
+		TlsCertFile:     certPath,
+		TlsKeyFile:      keyPath,
+		TlsMinVersion:   tls.VersionTLS12,
+		TlsClientCaFile: caPath,
+		TlsClientAllow:  []string{"alice"},
+		AccessLogFormat: AccessLogCommon,
+		AccessLogFile:   logPath,
+	}, nil), "http://")
+
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs}}}
+		resp, err := client.Get(baseUrl + "/")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return resp, err
+	}
+	resp, err := get(alice)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	for name, certs := range map[string][]tls.Certificate{"none": nil, "not allowed": {mallory}, "unknown issuer": {eve}} {
+		_, err := get(certs...)
+		assert.Error(t, err, name)
+	}
+
+	output := new(bytes.Buffer)
+	require.NoError(t, mainInner([]string{
+		"md-http", "healthcheck", "-listen", strings.TrimPrefix(baseUrl, "https://"), "-tls-cert", certPath,
+		"-tls-client-cert", filepath.Join(dir, "alice.crt"), "-tls-client-key", filepath.Join(dir, "alice.key"),
+	}, output))
+	assert.Equal(t, "healthy\n", output.String())
+
+	raw, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Regexp(t, `(?m)^127\.0\.0\.1 - alice \[.+\] "GET / HTTP/1\.1" 200 \d+$`, string(raw))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of md-http. Until tracing is set up the spans are not recorded.
+var tracer = otel.Tracer("github.com/astromechza/md-http")
+
+// tracingEnabled returns whether the standard OTEL_* environment variables ask for spans to be exported. An OTLP
+// endpoint or OTEL_TRACES_EXPORTER=otlp enables it, and OTEL_SDK_DISABLED or OTEL_TRACES_EXPORTER=none disables it.
+func tracingEnabled() bool {
+	if disabled, _ := strconv.ParseBool(os.Getenv("OTEL_SDK_DISABLED")); disabled {
+		return false
+	}
+	switch os.Getenv("OTEL_TRACES_EXPORTER") {
+	case "otlp":
+		return true
+	case "":
+		return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+	default:
+		return false
+	}
+}
+
+// setupTracing installs the W3C trace context propagator and, when enabled by the environment, a tracer provider that
+// exports the spans over OTLP. The endpoint, headers, timeout, sampler, and resource attributes are all read from the
+// standard OTEL_* environment variables. The returned function flushes the remaining spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if !tracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	var client otlptrace.Client
+	switch protocol {
+	case "", "http/protobuf":
+		protocol, client = "http/protobuf", otlptracehttp.NewClient()
+	case "grpc":
+		client = otlptracegrpc.NewClient()
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol '%s', expected 'http/protobuf' or 'grpc'", protocol)
+	}
+	exporter, err := otlptrace.New(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the trace exporter: %w", err)
+	}
+
+	version, _ := buildVersion()
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attribute.String("service.name", "md-http"), attribute.String("service.version", version)),
+		// the environment takes precedence so that OTEL_SERVICE_NAME can rename the service
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("failed to export spans", "err", err)
+	}))
+	slog.Info("Exporting traces over OTLP", "protocol", protocol)
+	return provider.Shutdown, nil
+}
+
+// startServerSpan continues the trace from the traceparent header of the request, if any, and starts the span for
+// serving it. The returned request carries the span in its context.
+func startServerSpan(request *http.Request, route string) (*http.Request, trace.Span) {
+	clientHost, _, _ := net.SplitHostPort(request.RemoteAddr)
+	ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+	ctx, span := tracer.Start(ctx, request.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", request.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", request.URL.Path),
+			attribute.String("user_agent.original", request.UserAgent()),
+			attribute.String("client.address", clientHost),
+			attribute.String("network.protocol.version", fmt.Sprintf("%d.%d", request.ProtoMajor, request.ProtoMinor)),
+		),
+	)
+	return request.WithContext(ctx), span
+}
+
+// endServerSpan records the response on the span and ends it. Only server errors mark the span as failed.
+func endServerSpan(span trace.Span, recorder *responseRecorder) {
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", recorder.StatusCode),
+		attribute.Int64("http.response.body.size", recorder.Written),
+	)
+	if recorder.StatusCode >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(recorder.StatusCode))
+	}
+	span.End()
+}
+
+// endSpan records the error, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracingEnabled(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		env      map[string]string
+		expected bool
+	}{
+		{name: "unset", env: map[string]string{}, expected: false},
+		{name: "endpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, expected: true},
+		{name: "traces endpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4318/v1/traces"}, expected: true},
+		{name: "exporter", env: map[string]string{"OTEL_TRACES_EXPORTER": "otlp"}, expected: true},
+		{name: "exporter none", env: map[string]string{"OTEL_TRACES_EXPORTER": "none", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, expected: false},
+		{name: "sdk disabled", env: map[string]string{"OTEL_SDK_DISABLED": "true", "OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318"}, expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, k := range []string{"OTEL_SDK_DISABLED", "OTEL_TRACES_EXPORTER", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"} {
+				t.Setenv(k, tc.env[k])
+			}
+			assert.Equal(t, tc.expected, tracingEnabled())
+		})
+	}
+}
+
+func TestRunTracing(t *testing.T) {
+	var lock sync.Mutex
+	var spans []*tracepb.Span
+	collector := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		raw, _ := io.ReadAll(request.Body)
+		export := new(collectortrace.ExportTraceServiceRequest)
+		if err := proto.Unmarshal(raw, export); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for _, rs := range export.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}))
+	defer collector.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", collector.URL+"/v1/traces")
+	t.Setenv("OTEL_BSP_SCHEDULE_DELAY", "10")
+
+	mdPath := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(mdPath, []byte("# example\n"), 0600))
+	baseUrl := startRun(t, argsStruct{MarkdownFile: mdPath, Edit: true}, nil)
+
+	traceId, parentId := "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	request, err := http.NewRequest("GET", baseUrl+"/", nil)
+	require.NoError(t, err)
+	request.Header.Set("traceparent", "00-"+traceId+"-"+parentId+"-01")
+	resp, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	findSpan := func(name string) *tracepb.Span {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, span := range spans {
+			if span.Name == name {
+				return span
+			}
+		}
+		return nil
+	}
+	assert.Eventually(t, func() bool {
+		return findSpan("GET /") != nil
+	}, time.Second*5, time.Millisecond*20)
+
+	server := findSpan("GET /")
+	require.NotNil(t, server)
+	assert.Equal(t, traceId, hex.EncodeToString(server.TraceId))
+	assert.Equal(t, parentId, hex.EncodeToString(server.ParentSpanId))
+	assert.Equal(t, tracepb.Span_SPAN_KIND_SERVER, server.Kind)
+	attributes := make(map[string]any)
+	for _, kv := range server.Attributes {
+		if _, ok := kv.Value.Value.(*commonpb.AnyValue_StringValue); ok {
+			attributes[kv.Key] = kv.Value.GetStringValue()
+		} else {
+			attributes[kv.Key] = kv.Value.GetIntValue()
+		}
+	}
+	assert.Equal(t, "/", attributes["http.route"])
+	assert.Equal(t, int64(200), attributes["http.response.status_code"])
+	assert.Greater(t, attributes["http.response.body.size"], int64(0))
+
+	load, render := findSpan("load content"), findSpan("render page")
+	require.NotNil(t, load)
+	require.NotNil(t, render)
+	assert.Equal(t, load.SpanId, render.ParentSpanId)
+	assert.Equal(t, load.TraceId, render.TraceId)
+
+	// the reload after an edit is part of the request that made it
+	editTraceId := "0af7651916cd43dd8448eb211c80319c"
+	request, err = http.NewRequest(http.MethodPut, baseUrl+"/", strings.NewReader("# edited\n"))
+	require.NoError(t, err)
+	request.Header.Set("If-Match", resp.Header.Get("Etag"))
+	request.Header.Set("traceparent", "00-"+editTraceId+"-"+parentId+"-01")
+	resp, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	findEditSpan := func(name string) *tracepb.Span {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, span := range spans {
+			if span.Name == name && hex.EncodeToString(span.TraceId) == editTraceId {
+				return span
+			}
+		}
+		return nil
+	}
+	assert.Eventually(t, func() bool {
+		return findEditSpan("PUT /") != nil && findEditSpan("load content") != nil
+	}, time.Second*5, time.Millisecond*20)
+	edit, editLoad := findEditSpan("PUT /"), findEditSpan("load content")
+	require.NotNil(t, edit)
+	require.NotNil(t, editLoad)
+	assert.Equal(t, edit.SpanId, editLoad.ParentSpanId)
+}
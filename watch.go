@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultWatchSettle is how long to wait after a filesystem event before reacting to it, so that a burst of events
+// from a single save or symlink swap results in one reload.
+const DefaultWatchSettle = time.Millisecond * 100
+
+// watchFiles calls onChange whenever any of the paths may have changed, until the context is cancelled. The paths
+// function is called again after every change, so that files which appear in a watched directory are picked up.
+// Changes are detected through filesystem notifications where the platform supports them, combined with a stat based
+// poll every interval. Notifications for other files in the same directories, such as editor swap files, are ignored.
+// The poll is the fallback for network filesystems and for symlink swaps, such as the ones used by Kubernetes ConfigMap
+// volumes, which do not always produce events for the watched path.
+func watchFiles(ctx context.Context, paths func() []string, interval time.Duration, onChange func()) {
+	events := make(chan string, 16)
+	addWatch, err := notifyChanges(ctx, events)
+	if err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			slog.Debug("filesystem notifications are not supported, falling back to polling", "interval", interval)
+		} else {
+			slog.Warn("failed to setup filesystem notifications, falling back to polling", "interval", interval, "err", err)
+		}
+	}
+	watchedDirs := make(map[string]bool)
+
+	// fingerprint the current paths and make sure their directories are being watched
+	fingerprints := make(map[string]fileFingerprint)
+	// watchedNames are the paths whose events matter, which includes the entries that symlinked paths pass through
+	watchedNames := make(map[string]bool)
+	refresh := func() {
+		fingerprints = make(map[string]fileFingerprint)
+		watchedNames = make(map[string]bool)
+		for _, p := range paths() {
+			f := fingerprintFile(p)
+			fingerprints[p] = f
+			watchedNames[p] = true
+			if hop := symlinkHop(p); hop != "" {
+				watchedNames[hop] = true
+			}
+			// we watch the parent directory of files rather than the file itself, because editors and ConfigMap updates
+			// replace the file (or a symlink leading to it) rather than writing to it in place
+			dir := filepath.Dir(p)
+			if f.info != nil && f.info.IsDir() {
+				dir = p
+			}
+			if addWatch != nil && !watchedDirs[dir] {
+				if err := addWatch(dir); err != nil {
+					slog.Warn("failed to watch directory, relying on polling", "path", dir, "err", err)
+				}
+				watchedDirs[dir] = true
+			}
+		}
+	}
+	refresh()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	settle := time.NewTimer(DefaultWatchSettle)
+	settle.Stop()
+	defer settle.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case name := <-events:
+			if relevantEvent(name, watchedNames, fingerprints) {
+				slog.Debug("detected file change by notification", "path", name)
+				settle.Reset(DefaultWatchSettle)
+			}
+		case <-settle.C:
+			onChange()
+			refresh()
+		case <-ticker.C:
+			current := paths()
+			changed := len(current) != len(fingerprints)
+			for _, p := range current {
+				if f, ok := fingerprints[p]; !ok || !fingerprintFile(p).Equal(f) {
+					slog.Debug("detected file change by polling", "path", p)
+					changed = true
+					break
+				}
+			}
+			if changed {
+				onChange()
+				refresh()
+			}
+		}
+	}
+}
+
+// relevantEvent returns whether a notification for the path may change the watched paths. The path is empty when
+// notifications were lost. Besides the watched paths themselves, new markdown files and directories that appear in a
+// watched directory count, so that they are picked up.
+func relevantEvent(path string, watchedNames map[string]bool, fingerprints map[string]fileFingerprint) bool {
+	if path == "" || watchedNames[path] {
+		return true
+	}
+	if f := fingerprints[filepath.Dir(path)]; f.info == nil || !f.info.IsDir() || strings.HasPrefix(filepath.Base(path), ".") {
+		return false
+	}
+	if filepath.Ext(path) == ".md" {
+		return true
+	}
+	info, err := os.Lstat(path)
+	return err == nil && info.IsDir()
+}
+
+// symlinkHop returns the entry next to the path that a relative symlink at the path passes through first, such as the
+// '..data' link that Kubernetes swaps to update a ConfigMap volume. It is empty when the path is not such a symlink.
+func symlinkHop(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil || filepath.IsAbs(target) {
+		return ""
+	}
+	first, _, _ := strings.Cut(filepath.ToSlash(filepath.Clean(target)), "/")
+	if first == "." || first == ".." {
+		return ""
+	}
+	return filepath.Join(filepath.Dir(path), first)
+}
+
+// fileFingerprint is the subset of the stat result of a file that we use to detect changes while polling.
+type fileFingerprint struct {
+	info os.FileInfo
+}
+
+func fingerprintFile(path string) fileFingerprint {
+	// os.Stat follows symlinks so that a swapped link target is seen as a different file
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileFingerprint{}
+	}
+	return fileFingerprint{info: info}
+}
+
+func (f fileFingerprint) Equal(other fileFingerprint) bool {
+	if f.info == nil || other.info == nil {
+		return f.info == other.info
+	}
+	return os.SameFile(f.info, other.info) &&
+		f.info.Size() == other.info.Size() &&
+		f.info.ModTime().Equal(other.info.ModTime())
+}
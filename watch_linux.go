@@ -0,0 +1,80 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// notifyChanges uses inotify to send the path of everything that changes in a watched directory on the events channel,
+// or an empty path when the kernel dropped events. It returns the function used to add directories to the watch.
+func notifyChanges(ctx context.Context, events chan<- string) (func(dir string) error, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init inotify: %w", err)
+	}
+	// the fd is non-blocking, so os.File registers it with the runtime poller and Close will interrupt a pending Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	var lock sync.Mutex
+	dirs := make(map[int32]string)
+
+	go func() {
+		<-ctx.Done()
+		_ = file.Close()
+	}()
+	go func() {
+		buffer := make([]byte, (syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)*16)
+		for {
+			n, err := file.Read(buffer)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("stopped reading filesystem notifications", "err", err)
+				}
+				return
+			}
+			// each event is the fixed size header followed by the nul padded name of the entry in the directory
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				wd := int32(binary.NativeEndian.Uint32(buffer[offset:]))
+				mask := binary.NativeEndian.Uint32(buffer[offset+4:])
+				nameLen := int(binary.NativeEndian.Uint32(buffer[offset+12:]))
+				name := string(bytes.TrimRight(buffer[offset+syscall.SizeofInotifyEvent:offset+syscall.SizeofInotifyEvent+nameLen], "\x00"))
+				offset += syscall.SizeofInotifyEvent + nameLen
+
+				path := ""
+				if mask&syscall.IN_Q_OVERFLOW == 0 {
+					lock.Lock()
+					dir, ok := dirs[wd]
+					lock.Unlock()
+					if !ok {
+						continue
+					}
+					path = filepath.Join(dir, name)
+				}
+				select {
+				case events <- path:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return func(dir string) error {
+		wd, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_MODIFY|syscall.IN_CLOSE_WRITE|syscall.IN_ATTRIB|
+			syscall.IN_CREATE|syscall.IN_DELETE|syscall.IN_MOVED_FROM|syscall.IN_MOVED_TO)
+		if err != nil {
+			return fmt.Errorf("failed to watch directory '%s': %w", dir, err)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		dirs[int32(wd)] = dir
+		return nil
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// notifyChanges is not implemented on this platform, so watchFiles falls back to polling.
+func notifyChanges(_ context.Context, _ chan<- string) (func(dir string) error, error) {
+	return nil, errors.ErrUnsupported
+}
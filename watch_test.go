@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchFiles_write(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "example.md")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0600))
+
+	var calls atomic.Int32
+	go watchFiles(ctx, func() []string { return []string{path} }, time.Hour, func() {
+		calls.Add(1)
+	})
+	time.Sleep(time.Millisecond * 50)
+	require.NoError(t, os.WriteFile(path, []byte("b"), 0600))
+	assert.Eventually(t, func() bool {
+		return calls.Load() >= 1
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestWatchFiles_pollSymlinkSwap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// mimic the layout of a kubernetes configmap volume where the data directory is swapped through a symlink
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "v1"), 0700))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "v2"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "v1", "example.md"), []byte("a"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "v2", "example.md"), []byte("b"), 0600))
+	require.NoError(t, os.Symlink("v1", filepath.Join(dir, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "example.md"), filepath.Join(dir, "example.md")))
+
+	assert.True(t, fingerprintFile(filepath.Join(dir, "example.md")).Equal(fingerprintFile(filepath.Join(dir, "v1", "example.md"))))
+
+	var calls atomic.Int32
+	go watchFiles(ctx, func() []string { return []string{filepath.Join(dir, "example.md")} }, time.Millisecond*20, func() {
+		calls.Add(1)
+	})
+	time.Sleep(time.Millisecond * 50)
+	require.NoError(t, os.Symlink("v2", filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	assert.Eventually(t, func() bool {
+		return calls.Load() >= 1
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestWatchFiles_newFileInDirectory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0700))
+
+	var calls atomic.Int32
+	go watchFiles(ctx, func() []string { return []string{dir, filepath.Join(dir, "sub")} }, time.Hour, func() {
+		calls.Add(1)
+	})
+	time.Sleep(time.Millisecond * 50)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "example.md"), []byte("a"), 0600))
+	assert.Eventually(t, func() bool {
+		return calls.Load() >= 1
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestWatchFiles_ignoresOtherFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0600))
+
+	var calls atomic.Int32
+	go watchFiles(ctx, func() []string { return []string{path} }, time.Hour, func() {
+		calls.Add(1)
+	})
+	time.Sleep(time.Millisecond * 50)
+	// editors and other tools write next to the markdown file
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".example.md.swp"), []byte("a"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("a"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.md"), []byte("a"), 0600))
+	time.Sleep(DefaultWatchSettle * 3)
+	assert.Equal(t, int32(0), calls.Load())
+
+	require.NoError(t, os.WriteFile(path, []byte("b"), 0600))
+	assert.Eventually(t, func() bool {
+		return calls.Load() >= 1
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestRelevantEvent(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.md")
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0700))
+	watchedNames := map[string]bool{dir: true, path: true}
+	fingerprints := map[string]fileFingerprint{dir: fingerprintFile(dir), path: fingerprintFile(path)}
+
+	assert.True(t, relevantEvent("", watchedNames, fingerprints))
+	assert.True(t, relevantEvent(path, watchedNames, fingerprints))
+	// new markdown files and directories in a watched directory are picked up
+	assert.True(t, relevantEvent(filepath.Join(dir, "new.md"), watchedNames, fingerprints))
+	assert.True(t, relevantEvent(filepath.Join(dir, "sub"), watchedNames, fingerprints))
+	assert.False(t, relevantEvent(filepath.Join(dir, "new.txt"), watchedNames, fingerprints))
+	assert.False(t, relevantEvent(filepath.Join(dir, ".new.md.tmp"), watchedNames, fingerprints))
+	assert.False(t, relevantEvent(filepath.Join(dir, ".hidden.md"), watchedNames, fingerprints))
+}
+
+func TestSymlinkHop(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "v1"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "v1", "example.md"), []byte("a"), 0600))
+	require.NoError(t, os.Symlink("v1", filepath.Join(dir, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "example.md"), filepath.Join(dir, "example.md")))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "v1", "example.md"), filepath.Join(dir, "absolute.md")))
+
+	assert.Equal(t, filepath.Join(dir, "..data"), symlinkHop(filepath.Join(dir, "example.md")))
+	assert.Equal(t, "", symlinkHop(filepath.Join(dir, "absolute.md")))
+	assert.Equal(t, "", symlinkHop(filepath.Join(dir, "v1", "example.md")))
+}
+
+func TestFileFingerprint_missing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "example.md")
+	missing := fingerprintFile(path)
+	assert.True(t, missing.Equal(fingerprintFile(path)))
+	require.NoError(t, os.WriteFile(path, []byte("a"), 0600))
+	assert.False(t, missing.Equal(fingerprintFile(path)))
+}